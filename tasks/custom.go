@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"context"
 	f "floe/workflow/flow"
 	"io"
 
@@ -11,7 +12,7 @@ type CustomTask struct {
 	customFunc CustomExecFunc
 }
 
-type CustomExecFunc func(t *f.TaskNode, p *f.Params, out *io.PipeWriter)
+type CustomExecFunc func(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter)
 
 func (ft *CustomTask) Type() string {
 	return "custom_task"
@@ -24,10 +25,10 @@ func MakeCustomTask(customFunc CustomExecFunc) *CustomTask {
 }
 
 // params are passed in and mutated with results
-func (ft *CustomTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
+func (ft *CustomTask) Exec(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 	glog.Info("executing custom task ", p.Complete)
 
-	ft.customFunc(t, p, out)
+	ft.customFunc(ctx, t, p, out)
 
 	return
 }
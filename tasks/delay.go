@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"context"
 	f "floe/workflow/flow"
 	"github.com/golang/glog"
 	"io"
@@ -22,10 +23,16 @@ func MakeDelayTask(delay time.Duration) *DelayTask {
 }
 
 // params are passed in and mutated with results
-func (ft *DelayTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
+func (ft *DelayTask) Exec(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 	glog.Info("executing delay ", p.Complete)
 
-	time.Sleep(ft.delay)
+	select {
+	case <-time.After(ft.delay):
+	case <-ctx.Done():
+		p.Response = "delay cancelled"
+		p.Status = f.CANCELLED
+		return
+	}
 
 	out.Write([]byte("Delay complete\n"))
 
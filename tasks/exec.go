@@ -1,12 +1,14 @@
 package tasks
 
 import (
+	"context"
 	f "floe/workflow/flow"
 	"io"
 	"os/exec"
 	// "strings"
 	"bufio"
 	"floe/log"
+	"strconv"
 	"syscall"
 )
 
@@ -28,7 +30,7 @@ func MakeExecTask(cmd, args, path string) ExecTask {
 	}
 }
 
-func (ft ExecTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
+func (ft ExecTask) Exec(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 	glog.Info("executing command")
 
 	cmd, ok := p.Props["cmd"]
@@ -50,12 +52,42 @@ func (ft ExecTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 	}
 
 	glog.Info("cmd: ", cmd, " args: >", args, "<")
-	argstr := cmd + " " + args
 
+	// fill in any ${var} - the values are quoted as they are going to the shell
+	argstr, err := p.ExpandShell(cmd + " " + args)
+	if err != nil {
+		failTemplate(p, out, err)
+		return
+	}
+
+	path, err := p.Expand(ft.path)
+	if err != nil {
+		failTemplate(p, out, err)
+		return
+	}
+
+	ft.run(ctx, t, p, out, argstr, path)
+}
+
+// the arguments could not be expanded
+func failTemplate(p *f.Params, out *io.PipeWriter, err error) {
+	glog.Error(err)
+	if out != nil {
+		out.Write([]byte(err.Error() + "\n\n"))
+	}
+	p.Status = f.FAIL
+	p.Response = err.Error()
+}
+
+// run the already expanded command line in path relative to the workspace
+func (ft ExecTask) run(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter, argstr, path string) {
 	eCmd := exec.Command("bash", "-c", argstr)
 
+	// run in its own process group so we can kill bash and all its children
+	eCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
 	// this is mandatory
-	eCmd.Dir = t.WorkFlow().Params.Props[f.KEY_WORKSPACE] + ft.path
+	eCmd.Dir = t.WorkFlow().Params.Props[f.KEY_WORKSPACE] + path
 	glog.Info("working directory: ", eCmd.Dir)
 
 	var err error
@@ -91,6 +123,18 @@ func (ft ExecTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 		return
 	}
 
+	// kill the whole process group if the flow is stopped or the task timed out
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			glog.Warning("killing command ", argstr)
+			syscall.Kill(-eCmd.Process.Pid, syscall.SIGKILL)
+		case <-finished:
+		}
+	}()
+
 	glog.Info("exec waiting")
 	err = eCmd.Wait()
 
@@ -106,11 +150,13 @@ func (ft ExecTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 				glog.Info("exit status: ", p.Status)
 			}
 		}
+		p.SetOutput("exit-status", strconv.Itoa(p.ExitStatus))
 		// we prefer to return 0 for good or one for bad
 		p.Status = f.FAIL
 		return
 	}
 
+	p.SetOutput("exit-status", "0")
 	p.Response = "exec command done"
 	p.Status = f.SUCCESS
 
@@ -121,7 +167,7 @@ func (ft ExecTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 // execute the command but capture the output in string array
 // forward = shall we forward to the command list (to show in the web page)
 // most triggers which loop round - should set this false
-func (ft ExecTask) ExecCapture(t *f.TaskNode, p *f.Params, out *io.PipeWriter, forward bool) ([]string, error) {
+func (ft ExecTask) ExecCapture(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter, forward bool) ([]string, error) {
 	glog.Info("exec capture", t.Id())
 	var err error
 	commandOutput := []string{}
@@ -145,7 +191,7 @@ func (ft ExecTask) ExecCapture(t *f.TaskNode, p *f.Params, out *io.PipeWriter, f
 	}()
 
 	// and add it to the results
-	ft.Exec(t, p, wp)
+	ft.Exec(ctx, t, p, wp)
 
 	glog.Info("Exec Captured: ", commandOutput)
 	return commandOutput, err
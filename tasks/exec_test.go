@@ -2,6 +2,7 @@ package tasks
 
 import (
 	"bufio"
+	"context"
 	f "floe/workflow/flow"
 	"fmt"
 	"io"
@@ -37,7 +38,7 @@ func Test_Exec(t *testing.T) {
 		}
 	}()
 
-	tsk.Exec(tn, p, w)
+	tsk.Exec(context.Background(), tn, p, w)
 
 	t.Log("executed")
 
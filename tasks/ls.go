@@ -1,11 +1,12 @@
 package tasks
 
 import (
+	"context"
 	f "floe/workflow/flow"
-	"fmt"
 	"github.com/golang/glog"
 	"io"
 	"io/ioutil"
+	"strings"
 )
 
 type LsTask struct {
@@ -23,7 +24,7 @@ func MakeLsTask(path string) *LsTask {
 }
 
 // params are passed in and mutated with results
-func (ft *LsTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
+func (ft *LsTask) Exec(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 	glog.Info("executing list directory ", p.Complete)
 
 	path, ok := p.Props["path"]
@@ -39,14 +40,25 @@ func (ft *LsTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 		return
 	}
 
+	path, err := p.Expand(path)
+	if err != nil {
+		p.Status = f.FAIL
+		p.Response = err.Error()
+		return
+	}
+
 	// this is mandatory node
 	path = t.WorkFlow().Params.Props[f.KEY_WORKSPACE] + "/" + path
 
 	files, _ := ioutil.ReadDir(path)
+	names := make([]string, 0, len(files))
 	for _, f := range files {
-		p.Props[fmt.Sprint(f.Name())] = ""
+		names = append(names, f.Name())
 	}
 
+	// one name per line
+	p.SetOutput("files", strings.Join(names, "\n"))
+
 	p.Response = "list directory done"
 	p.Status = f.SUCCESS
 
@@ -1,14 +1,17 @@
 package tasks
 
 import (
+	"context"
 	f "floe/workflow/flow"
 	"github.com/golang/glog"
 	"io"
 )
 
 type SSHExecTask struct {
-	task   ExecTask
-	remote string
+	task       ExecTask
+	node       string
+	remotePath string
+	cmd        string
 
 	//echo "cd danmux/m-playbook; ansible-playbook v3_play.yml -i staging/inventory -l bricks -C" | ssh vstag /bin/bash
 }
@@ -17,24 +20,43 @@ func (ft SSHExecTask) Type() string {
 	return "ssh exec"
 }
 
+// the remote path and cmd can use ${var} - the cmd is run on node by piping it to bash over ssh
 func MakeSSHExecTask(node, remotePAth, cmd, path string) SSHExecTask {
+	return SSHExecTask{
+		node:       node,
+		remotePath: remotePAth,
+		cmd:        cmd,
+		task:       MakeExecTask("echo", "", path),
+	}
+}
 
-	sshCmd := "\"" + cmd + "\""
+func (ft SSHExecTask) Exec(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
+	glog.Info("executing ssh command")
 
-	if remotePAth != "" {
-		sshCmd = "\"cd " + remotePAth + "; " + cmd + "\""
+	// values are quoted for the remote shell
+	script, err := p.ExpandShell(ft.cmd)
+	if err != nil {
+		failTemplate(p, out, err)
+		return
 	}
 
-	sshCmd = sshCmd + " | ssh " + node + " /bin/bash"
+	if ft.remotePath != "" {
+		remotePath, err := p.ExpandShell(ft.remotePath)
+		if err != nil {
+			failTemplate(p, out, err)
+			return
+		}
+		script = "cd " + remotePath + "; " + script
+	}
 
-	return SSHExecTask{
-		remote: node,
-		task:   MakeExecTask("echo", sshCmd, path),
+	path, err := p.Expand(ft.task.path)
+	if err != nil {
+		failTemplate(p, out, err)
+		return
 	}
-}
 
-func (ft SSHExecTask) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
-	glog.Info("executing ssh command")
+	// and the whole script is quoted for the local shell
+	sshCmd := "echo " + f.ShellQuote(script) + " | ssh " + f.ShellQuote(ft.node) + " /bin/bash"
 
-	ft.task.Exec(t, p, out)
+	ft.task.run(ctx, t, p, out, sshCmd, path)
 }
@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"context"
 	"encoding/json"
 	"floe/log"
 	"floe/tasks"
@@ -44,19 +45,25 @@ func MakeGitPushTrigger(repoUrl, branch string, interval time.Duration) *Trigger
 }
 
 // params are passed in and mutated with results
-func (ft *TriggerOnGitPush) Exec(t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
+func (ft *TriggerOnGitPush) Exec(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter) {
 	glog.Info("starting git pull trigger ", p.Complete, out)
 
 	for {
-		time.Sleep(ft.interval)
+		select {
+		case <-time.After(ft.interval):
+		case <-ctx.Done():
+			p.Response = "trigger cancelled"
+			p.Status = f.CANCELLED
+			return
+		}
 
-		if ft.ExecOnce(t, p, out) {
+		if ft.ExecOnce(ctx, t, p, out) {
 			return
 		}
 	}
 }
 
-func (ft *TriggerOnGitPush) ExecOnce(t *f.TaskNode, p *f.Params, out *io.PipeWriter) bool {
+func (ft *TriggerOnGitPush) ExecOnce(ctx context.Context, t *f.TaskNode, p *f.Params, out *io.PipeWriter) bool {
 	// get task folder location
 	hashesFile := p.Props[f.KEY_TRIGGERS] + "/" + t.Id() + ".state.json"
 
@@ -66,7 +73,7 @@ func (ft *TriggerOnGitPush) ExecOnce(t *f.TaskNode, p *f.Params, out *io.PipeWri
 	// get log from url
 	gitCommand := tasks.MakeExecTask("git", "ls-remote "+ft.repoUrl, "")
 
-	outCommands, err := gitCommand.ExecCapture(t, p, out, false)
+	outCommands, err := gitCommand.ExecCapture(ctx, t, p, out, false)
 
 	if err == nil && len(outCommands) > 2 {
 
@@ -97,6 +104,9 @@ func (ft *TriggerOnGitPush) ExecOnce(t *f.TaskNode, p *f.Params, out *io.PipeWri
 			p.Props["git-trigger-hash"] = hash
 			p.Props["git-trigger-branch"] = branch
 
+			p.SetOutput("hash", hash)
+			p.SetOutput("branch", branch)
+
 			out.Write([]byte("triggering: " + t.Id() + "\n"))
 			out.Write([]byte("for branch: " + branch + "\n"))
 			out.Write([]byte("with hash: " + hash + "\n"))
@@ -2,6 +2,7 @@ package tasks
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	f "floe/workflow/flow"
 	"fmt"
@@ -177,6 +178,6 @@ func Test_RealGitPushTrigger(t *testing.T) {
 		}
 	}()
 
-	pt.Exec(tn, p, wp)
+	pt.Exec(context.Background(), tn, p, wp)
 
 }
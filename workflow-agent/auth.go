@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"floe/workflow/auth"
+	"net/http"
+	"strings"
+)
+
+// who can use the api - nil leaves it open to anyone that can reach the agent
+var agentAuth *auth.Auth
+
+// the origins browsers may call the api from - * for any
+var corsOrigins = []string{"*"}
+
+type ctxKey int
+
+const userKey ctxKey = 0
+
+// the user that made the request - nil if there is no auth
+func currentUser(req *http.Request) *auth.User {
+	u, _ := req.Context().Value(userKey).(*auth.User)
+	return u
+}
+
+// the name of the user that made the request - empty if there is no auth
+func userName(req *http.Request) string {
+	if u := currentUser(req); u != nil {
+		return u.Name
+	}
+	return ""
+}
+
+// only let in users with at least the role on some flow - the handlers that act on one flow check
+// the role on it with allowed. Preflight requests are answered here without credentials.
+func protect(role auth.Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "OPTIONS" {
+			corsHeaders(w, req)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if agentAuth == nil {
+			h(w, req)
+			return
+		}
+
+		u, err := agentAuth.Authenticate(req)
+		if err != nil {
+			JsonHeaders(w, req)
+			if agentAuth.Realm != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+agentAuth.Realm+`"`)
+			}
+			respondWithError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if u.MaxRole() < role {
+			JsonHeaders(w, req)
+			respondWithError(w, http.StatusForbidden, u.Name+" needs the "+role.String()+" role")
+			return
+		}
+
+		h(w, req.WithContext(context.WithValue(req.Context(), userKey, u)))
+	}
+}
+
+// can the user of the request act with the role on the flow - responds forbidden if not
+func allowed(w http.ResponseWriter, req *http.Request, flowId string, role auth.Role) bool {
+	u := currentUser(req)
+	if u == nil || u.Can(flowId, role) {
+		return true
+	}
+	respondWithError(w, http.StatusForbidden, u.Name+" needs the "+role.String()+" role on flow "+flowId)
+	return false
+}
+
+// can the user of the request see the flow - without responding
+func canView(req *http.Request, flowId string) bool {
+	u := currentUser(req)
+	return u == nil || u.Can(flowId, auth.VIEWER)
+}
+
+// let the browser call the api from the origin if it is one of the cors origins
+func corsHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	for _, o := range corsOrigins {
+		if o == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			break
+		}
+		if o == origin {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			break
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, OPTIONS, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(r.Header["Access-Control-Request-Headers"], ","))
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"floe/workflow/auth"
+	f "floe/workflow/flow"
+	"fmt"
 	"github.com/codegangsta/negroni"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -12,10 +17,30 @@ const rootFolder = "/build"
 
 type ExecInstruction struct {
 	Id      string
+	RunId   string // to stop just this run of the flow
 	Command string
 	Delay   time.Duration
 }
 
+// the run an exec started - or where it is waiting in the queue
+type ExecResponse struct {
+	Id       string
+	RunId    string // empty while the run is queued
+	QueueId  string // to cancel the run while it is queued
+	Position int    // in the queue - zero if it started straight away
+}
+
+type QueueInstruction struct {
+	Id string // the queue id
+}
+
+// the runs waiting to start and how many the queue has going
+type QueueResponse struct {
+	MaxRuns int
+	Running int
+	Queued  []f.QueuedRun
+}
+
 // api/exec
 func execHandler(w http.ResponseWriter, req *http.Request) {
 	JsonHeaders(w, req)
@@ -27,23 +52,37 @@ func execHandler(w http.ResponseWriter, req *http.Request) {
 
 		err := decodeBody(req, &v)
 		if err != nil {
-			respondWithJson(w, http.StatusNotAcceptable, err.Error())
+			respondWithError(w, http.StatusNotAcceptable, err.Error())
 			return
 		}
 
 		v.Delay = v.Delay * time.Second
 
-		_, err = exec_async(v.Id, v.Delay)
+		if !allowed(w, req, v.Id, auth.OPERATOR) {
+			return
+		}
+
+		qr, err := exec_async(v.Id, userName(req), v.Delay)
+
+		if ie, ok := err.(*invalidFlowError); ok {
+			respondWithIssues(w, ie)
+			return
+		}
 
 		if err != nil {
-			respondWithJson(w, http.StatusInternalServerError, err.Error())
+			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		respondWithJson(w, http.StatusOK, nil)
+		respondWithJson(w, http.StatusOK, ExecResponse{
+			Id:       v.Id,
+			RunId:    qr.RunId,
+			QueueId:  qr.Id,
+			Position: qr.Position,
+		})
 
 	} else {
-		respondWithJson(w, http.StatusMethodNotAllowed, "method not allowed")
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
@@ -55,21 +94,29 @@ func stopHandler(w http.ResponseWriter, req *http.Request) {
 
 		err := decodeBody(req, &v)
 		if err != nil {
-			respondWithJson(w, http.StatusNotAcceptable, err.Error())
+			respondWithError(w, http.StatusNotAcceptable, err.Error())
 			return
 		}
 
-		err = stop(v.Id)
+		if !allowed(w, req, v.Id, auth.OPERATOR) {
+			return
+		}
+
+		if v.RunId != "" {
+			err = stopRun(v.Id, v.RunId)
+		} else {
+			err = stop(v.Id)
+		}
 
 		if err != nil {
-			respondWithJson(w, http.StatusInternalServerError, err.Error())
+			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
 		respondWithJson(w, http.StatusOK, nil)
 
 	} else {
-		respondWithJson(w, http.StatusMethodNotAllowed, "method not allowed")
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
@@ -78,11 +125,714 @@ func curStatHandler(w http.ResponseWriter, req *http.Request) {
 
 	if req.Method == "GET" {
 
-		project.ColectResults()
+		// just the flows the user can see - each result is marshalled under its own lock
+		results := map[string]*f.FlowLaunchResult{}
+		for id, res := range project.ColectResults() {
+			if canView(req, id) {
+				results[id] = res
+			}
+		}
+
+		respondWithJson(w, http.StatusOK, results)
+	} else {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
 
-		respondWithJson(w, http.StatusOK, project.LastResults)
+// api/status/run?id=<run-id> - a run in progress or from the history
+func runStatHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method == "GET" {
+		id := req.URL.Query().Get("id")
+		r := project.Run(id)
+		if r == nil {
+			respondWithError(w, http.StatusNotFound, "run not found: "+id)
+			return
+		}
+		if !allowed(w, req, r.FlowId, auth.VIEWER) {
+			return
+		}
+		respondWithJson(w, http.StatusOK, r)
 	} else {
-		respondWithJson(w, http.StatusMethodNotAllowed, "method not allowed")
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// api/queue - the runs waiting for room to start, the next to start first
+func queueHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method == "GET" {
+		res := QueueResponse{
+			MaxRuns: project.MaxRuns,
+			Running: queue.Running(""),
+			Queued:  []f.QueuedRun{},
+		}
+		for _, qr := range queue.Queued() {
+			if canView(req, qr.FlowId) {
+				res.Queued = append(res.Queued, qr)
+			}
+		}
+		respondWithJson(w, http.StatusOK, res)
+	} else {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// api/queue/cancel - take a run out of the queue before it starts
+func queueCancelHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method == "POST" {
+		v := QueueInstruction{}
+
+		err := decodeBody(req, &v)
+		if err != nil {
+			respondWithError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+
+		if !allowed(w, req, queuedFlow(v.Id), auth.OPERATOR) {
+			return
+		}
+
+		qr, err := cancelQueued(v.Id)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		respondWithJson(w, http.StatusOK, qr)
+
+	} else {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// the body of every error from the api
+type ErrorResponse struct {
+	Error ErrorDetail
+}
+
+type ErrorDetail struct {
+	Code    int
+	Message string
+	Issues  interface{} `json:",omitempty"` // what is wrong with a flow that can not run
+}
+
+func respondWithError(w http.ResponseWriter, code int, msg string) {
+	respondWithJson(w, code, ErrorResponse{Error: ErrorDetail{Code: code, Message: msg}})
+}
+
+func respondWithIssues(w http.ResponseWriter, ie *invalidFlowError) {
+	code := http.StatusUnprocessableEntity
+	respondWithJson(w, code, ErrorResponse{Error: ErrorDetail{Code: code, Message: ie.Error(), Issues: ie.Issues}})
+}
+
+// a page of the runs of a flow - newest first
+type RunsResponse struct {
+	Flow    string
+	Page    int
+	PerPage int
+	Total   int // the runs that matched the filter
+	Runs    []f.RunSummary
+}
+
+// an optional positive number from the query - def if it is not there
+func queryInt(req *http.Request, name string, def int) (int, error) {
+	s := req.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, errors.New(name + " must be a number above zero")
+	}
+	return n, nil
+}
+
+// the statuses in a comma separated list of names or numbers
+func queryStatuses(req *http.Request) ([]int, error) {
+	statuses := []int{}
+	for _, s := range strings.Split(req.URL.Query().Get("status"), ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if st, ok := f.StatusValues[s]; ok {
+			statuses = append(statuses, st)
+			continue
+		}
+		st, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.New("unknown status: " + s)
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// api/runs?flow=<flow-id>&status=fail,timeout&page=1&per_page=20 - the runs of a flow from its history
+func runsHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method != "GET" {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	flowId := req.URL.Query().Get("flow")
+	if !allowed(w, req, flowId, auth.VIEWER) {
+		return
+	}
+	rl, ok := project.RunList[flowId]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "no history for flow: "+flowId)
+		return
+	}
+
+	page, err := queryInt(req, "page", 1)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	perPage, err := queryInt(req, "per_page", 20)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if perPage > 100 {
+		perPage = 100
+	}
+	statuses, err := queryStatuses(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	runs, total := rl.Page(statuses, page, perPage)
+	res := RunsResponse{
+		Flow:    flowId,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+		Runs:    []f.RunSummary{},
+	}
+	for _, r := range runs {
+		res.Runs = append(res.Runs, r.Summary())
+	}
+
+	respondWithJson(w, http.StatusOK, res)
+}
+
+// api/runs/run?id=<run-id> - GET the run or DELETE it from the history
+func runHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	id := req.URL.Query().Get("id")
+
+	switch req.Method {
+	case "GET":
+		r := project.Run(id)
+		if r == nil {
+			respondWithError(w, http.StatusNotFound, "run not found: "+id)
+			return
+		}
+		if !allowed(w, req, r.FlowId, auth.VIEWER) {
+			return
+		}
+		respondWithJson(w, http.StatusOK, r)
+
+	case "DELETE":
+		r := project.Run(id)
+		if r == nil {
+			respondWithError(w, http.StatusNotFound, "run not found: "+id)
+			return
+		}
+		if !allowed(w, req, r.FlowId, auth.ADMIN) {
+			return
+		}
+		if err := project.DeleteRun(id); err != nil {
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		respondWithJson(w, http.StatusOK, nil)
+
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// api/runs/result?id=<run-id> - the stats and output of every task of the run
+func runResultHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method != "GET" {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := req.URL.Query().Get("id")
+	r := project.Run(id)
+	if r == nil {
+		respondWithError(w, http.StatusNotFound, "run not found: "+id)
+		return
+	}
+	if !allowed(w, req, r.FlowId, auth.VIEWER) {
+		return
+	}
+	res := r.CurrentResult()
+	if res == nil {
+		respondWithError(w, http.StatusNotFound, "run has no results: "+id)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, res)
+}
+
+// api/runs/output?id=<run-id>&task=<task-id> - the output of one task of the run
+func runOutputHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method != "GET" {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := req.URL.Query().Get("id")
+	r := project.Run(id)
+	if r == nil {
+		respondWithError(w, http.StatusNotFound, "run not found: "+id)
+		return
+	}
+	if !allowed(w, req, r.FlowId, auth.VIEWER) {
+		return
+	}
+
+	taskId := req.URL.Query().Get("task")
+	res := r.CurrentResult()
+	if res == nil {
+		respondWithError(w, http.StatusNotFound, "task not found: "+taskId)
+		return
+	}
+	to, ok := res.TaskOutput(taskId)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "task not found: "+taskId)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, to)
+}
+
+// api/runs/tail?id=<run-id>&task=<task-id>&from=<line> - a server-sent event stream of the output of the
+// task line by line as it is produced, each line event has the line to carry on from as its id so a
+// client that reconnects (with Last-Event-ID) or passes it as from picks up where it left off, an end
+// event says the run is over and there will be no more
+func runTailHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method != "GET" {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	q := req.URL.Query()
+	r := project.Run(q.Get("id"))
+	if r == nil {
+		respondWithError(w, http.StatusNotFound, "run not found: "+q.Get("id"))
+		return
+	}
+	if !allowed(w, req, r.FlowId, auth.VIEWER) {
+		return
+	}
+	taskId := q.Get("task")
+
+	cursor := q.Get("from")
+	if last := req.Header.Get("Last-Event-ID"); last != "" {
+		cursor = last
+	}
+	from := 0
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "from must be a line number")
+			return
+		}
+		from = n
+	}
+
+	// the run may still be running its initial flow
+	res := r.CurrentResult()
+	for res == nil && r.Summary().Running {
+		select {
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+			return
+		}
+		res = r.CurrentResult()
+	}
+	if res == nil {
+		respondWithError(w, http.StatusNotFound, "run has no results: "+r.Id)
+		return
+	}
+	if _, _, ok := res.TailOutput(taskId, 0); !ok {
+		respondWithError(w, http.StatusNotFound, "task not found: "+taskId)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		// look before reading so the lines written before the run ended are all sent
+		over := !r.Summary().Running
+
+		lines, more, _ := res.TailOutput(taskId, from)
+		for _, l := range lines {
+			from++
+			fmt.Fprintf(w, "id: %d\nevent: line\ndata: %s\n\n", from, strings.Replace(l, "\r", "", -1))
+		}
+		if over {
+			fmt.Fprintf(w, "id: %d\nevent: end\ndata: %d\n\n", from, from)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-more:
+		case <-time.After(time.Second): // to notice the run has ended
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// api/runs/log?id=<run-id>&task=<task-id> - the whole output of the task so far as a plain text download
+func runLogHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		JsonHeaders(w, req)
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := req.URL.Query()
+	r := project.Run(q.Get("id"))
+	if r == nil {
+		JsonHeaders(w, req)
+		respondWithError(w, http.StatusNotFound, "run not found: "+q.Get("id"))
+		return
+	}
+	if !allowed(w, req, r.FlowId, auth.VIEWER) {
+		return
+	}
+
+	taskId := q.Get("task")
+	var lines []string
+	ok := false
+	if res := r.CurrentResult(); res != nil {
+		lines, _, ok = res.TailOutput(taskId, 0)
+	}
+	if !ok {
+		JsonHeaders(w, req)
+		respondWithError(w, http.StatusNotFound, "task not found: "+taskId)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", r.Id+"-"+taskId+".log"))
+	corsHeaders(w, req)
+	w.WriteHeader(http.StatusOK)
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+}
+
+// api/events?flow=<flow-id>&run=<run-id> - a server-sent event stream of the runs of the flow, or just
+// the one run, or every run if neither is given. A client that reconnects with a Last-Event-ID gets the
+// events it missed that the agent still has. When events are missed a dropped event says how many.
+func eventsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		JsonHeaders(w, req)
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		JsonHeaders(w, req)
+		respondWithError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	q := req.URL.Query()
+	flowId := q.Get("flow")
+	if r := project.Run(q.Get("run")); r != nil {
+		flowId = r.FlowId
+	}
+	if !allowed(w, req, flowId, auth.VIEWER) {
+		return
+	}
+
+	last := int64(-1)
+	if id := req.Header.Get("Last-Event-ID"); id != "" {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			JsonHeaders(w, req)
+			respondWithError(w, http.StatusBadRequest, "Last-Event-ID must be an event Seq")
+			return
+		}
+		last = n
+	}
+
+	// without a flow or run the stream has every flow in it - so leave out the ones the user can't see
+	visible := func(e f.Event) bool { return canView(req, e.FlowId) }
+
+	sub := f.Events.Resume(q.Get("flow"), q.Get("run"), 256, last, visible)
+	defer f.Events.Unsubscribe(sub)
+
+	corsHeaders(w, req)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// let the client know when it has missed events - the total so far
+	var dropped int64
+	sendDropped := func() {
+		if d := sub.Dropped(); d > dropped {
+			dropped = d
+			fmt.Fprintf(w, "event: dropped\ndata: {\"Dropped\": %d}\n\n", d)
+		}
+	}
+	sendDropped()
+	flusher.Flush()
+
+	// a comment now and then keeps proxies from closing a quiet stream
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e := <-sub.C:
+			b, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			sendDropped()
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Kind, b)
+			flusher.Flush()
+		case <-keepAlive.C:
+			sendDropped()
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+type ApprovalInstruction struct {
+	Id      string
+	User    string
+	Comment string
+}
+
+// api/approvals - the gates waiting for a decision
+func approvalsHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method == "GET" {
+		pending := []*f.Approval{}
+		for _, a := range f.Approvals.Pending() {
+			if canView(req, f.MakeID(a.FlowName)) {
+				pending = append(pending, a)
+			}
+		}
+		respondWithJson(w, http.StatusOK, pending)
+	} else {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// api/approvals/approve and api/approvals/reject
+func decideHandler(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		JsonHeaders(w, req)
+
+		if req.Method == "POST" {
+			v := ApprovalInstruction{}
+
+			err := decodeBody(req, &v)
+			if err != nil {
+				respondWithError(w, http.StatusNotAcceptable, err.Error())
+				return
+			}
+
+			// the user that signed in made the decision
+			if name := userName(req); name != "" {
+				v.User = name
+			}
+
+			if v.User == "" {
+				respondWithError(w, http.StatusNotAcceptable, "a user is required")
+				return
+			}
+
+			if !allowed(w, req, approvalFlow(v.Id), auth.OPERATOR) {
+				return
+			}
+
+			err = decide(v.Id, approve, v.User, v.Comment)
+			if err != nil {
+				respondWithError(w, http.StatusNotFound, err.Error())
+				return
+			}
+
+			respondWithJson(w, http.StatusOK, nil)
+
+		} else {
+			respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+type DebugInstruction struct {
+	Id          string   // the flow
+	RunId       string   // the run to debug - as returned by start
+	Paused      bool     // start with every node waiting
+	Breakpoints []string // node ids to start with breakpoints on
+	StepId      string   // the pending step to release or edit - step releases the oldest if not set
+	Set         []string // breakpoints to set
+	Clear       []string // breakpoints to clear
+	Props       f.Props  // props to change on the pending step
+	Delete      []string // props to remove from the pending step
+}
+
+// api/debug?id=<flow>&run=<run-id> - the debugger state including the pending nodes and their params
+func debugStateHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method == "GET" {
+		flowId := req.URL.Query().Get("id")
+		if !allowed(w, req, flowId, auth.VIEWER) {
+			return
+		}
+		d, err := debugger(flowId, req.URL.Query().Get("run"))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		respondWithJson(w, http.StatusOK, d.State())
+	} else {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// api/debug/<action> - start, step, pause, continue, breakpoints or params
+func debugHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		JsonHeaders(w, req)
+
+		if req.Method != "POST" {
+			respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		v := DebugInstruction{}
+		err := decodeBody(req, &v)
+		if err != nil {
+			respondWithError(w, http.StatusNotAcceptable, err.Error())
+			return
+		}
+
+		if !allowed(w, req, v.Id, auth.OPERATOR) {
+			return
+		}
+
+		if action == "start" {
+			r, err := startDebug(v.Id, userName(req), v.Paused, v.Breakpoints)
+			if ie, ok := err.(*invalidFlowError); ok {
+				respondWithIssues(w, ie)
+				return
+			}
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			respondWithJson(w, http.StatusOK, ExecResponse{Id: v.Id, RunId: r.Id})
+			return
+		}
+
+		d, err := debugger(v.Id, v.RunId)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		switch action {
+		case "step":
+			if v.StepId != "" {
+				err = d.Release(v.StepId)
+			} else {
+				_, err = d.Step()
+			}
+		case "pause":
+			d.Pause()
+		case "continue":
+			d.Continue()
+		case "breakpoints":
+			for _, b := range v.Set {
+				d.SetBreakpoint(b)
+			}
+			for _, b := range v.Clear {
+				d.ClearBreakpoint(b)
+			}
+		case "params":
+			err = d.EditProps(v.StepId, v.Props, v.Delete)
+		}
+
+		if err != nil {
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		respondWithJson(w, http.StatusOK, d.State())
+	}
+}
+
+// api/validate - the structural problems with all the flows
+func validateHandler(w http.ResponseWriter, req *http.Request) {
+	JsonHeaders(w, req)
+
+	if req.Method == "GET" {
+		// just the flows the user can see
+		res := map[string]f.ValidationIssues{}
+		for id, issues := range project.Validate() {
+			if canView(req, id) {
+				res[id] = issues
+			}
+		}
+		respondWithJson(w, http.StatusOK, res)
+	} else {
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
@@ -98,10 +848,7 @@ func decodeBody(req *http.Request, v interface{}) error {
 
 func JsonHeaders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, OPTIONS, DELETE")
-
-	w.Header().Set("Access-Control-Allow-Headers", strings.Join(r.Header["Access-Control-Request-Headers"], ","))
+	corsHeaders(w, r)
 }
 
 func respondWithJson(w http.ResponseWriter, code int, v interface{}) {
@@ -110,8 +857,7 @@ func respondWithJson(w http.ResponseWriter, code int, v interface{}) {
 	if v != nil {
 		b, err = json.MarshalIndent(v, "", "  ")
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 	}
@@ -123,14 +869,33 @@ func respondWithJson(w http.ResponseWriter, code int, v interface{}) {
 func runWeb(host string) {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc(rootFolder+"/api/exec", execHandler)
-	mux.HandleFunc(rootFolder+"/api/status/current", curStatHandler)
-	mux.HandleFunc(rootFolder+"/api/stop", stopHandler)
+	// the least role needed for each endpoint - the handlers check the role on the flow they act on
+	mux.HandleFunc(rootFolder+"/api/exec", protect(auth.OPERATOR, execHandler))
+	mux.HandleFunc(rootFolder+"/api/status/current", protect(auth.VIEWER, curStatHandler))
+	mux.HandleFunc(rootFolder+"/api/status/run", protect(auth.VIEWER, runStatHandler))
+	mux.HandleFunc(rootFolder+"/api/stop", protect(auth.OPERATOR, stopHandler))
+	mux.HandleFunc(rootFolder+"/api/runs", protect(auth.VIEWER, runsHandler))
+	mux.HandleFunc(rootFolder+"/api/runs/run", protect(auth.VIEWER, runHandler)) // DELETE needs admin on the flow
+	mux.HandleFunc(rootFolder+"/api/runs/result", protect(auth.VIEWER, runResultHandler))
+	mux.HandleFunc(rootFolder+"/api/runs/output", protect(auth.VIEWER, runOutputHandler))
+	mux.HandleFunc(rootFolder+"/api/runs/tail", protect(auth.VIEWER, runTailHandler))
+	mux.HandleFunc(rootFolder+"/api/runs/log", protect(auth.VIEWER, runLogHandler))
+	mux.HandleFunc(rootFolder+"/api/events", protect(auth.VIEWER, eventsHandler))
+	mux.HandleFunc(rootFolder+"/api/queue", protect(auth.VIEWER, queueHandler))
+	mux.HandleFunc(rootFolder+"/api/queue/cancel", protect(auth.OPERATOR, queueCancelHandler))
+	mux.HandleFunc(rootFolder+"/api/validate", protect(auth.VIEWER, validateHandler))
+	mux.HandleFunc(rootFolder+"/api/approvals", protect(auth.VIEWER, approvalsHandler))
+	mux.HandleFunc(rootFolder+"/api/approvals/approve", protect(auth.OPERATOR, decideHandler(true)))
+	mux.HandleFunc(rootFolder+"/api/approvals/reject", protect(auth.OPERATOR, decideHandler(false)))
+	mux.HandleFunc(rootFolder+"/api/debug", protect(auth.VIEWER, debugStateHandler))
+	for _, action := range []string{"start", "step", "pause", "continue", "breakpoints", "params"} {
+		mux.HandleFunc(rootFolder+"/api/debug/"+action, protect(auth.OPERATOR, debugHandler(action)))
+	}
 
-	mux.HandleFunc(rootFolder+"/api/flow", func(w http.ResponseWriter, req *http.Request) {
+	mux.HandleFunc(rootFolder+"/api/flow", protect(auth.VIEWER, func(w http.ResponseWriter, req *http.Request) {
 		JsonHeaders(w, req)
-		w.Write(project.ToJson())
-	})
+		w.Write(project.ToJsonOf(func(flowId string) bool { return canView(req, flowId) }))
+	}))
 
 	n := negroni.Classic()
 	// n := negroni.New()
@@ -1,15 +1,104 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"customfloe"
+	"encoding/json"
 	"flag"
+	"floe/workflow/auth"
+	f "floe/workflow/flow"
+	"floe/workflow/loader"
 	"fmt"
+	"github.com/golang/glog"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
-// command line with 2 second step delay
-func runCommandLine(id string) {
-	exec(id, 1*time.Second)
+// command line with 2 second step delay - any gates are approved at the prompt
+func runCommandLine(id, user string) {
+	stop := make(chan bool)
+	go promptApprovals(user, stop)
+	exec(id, user, 1*time.Second)
+	close(stop)
+}
+
+// ask on the terminal for a decision on each gate as it starts waiting
+func promptApprovals(user string, stop chan bool) {
+	in := bufio.NewReader(os.Stdin)
+	asked := map[string]bool{}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		for _, a := range f.Approvals.Pending() {
+			if asked[a.Id] {
+				continue
+			}
+			asked[a.Id] = true
+
+			fmt.Printf("\n%s (%s) needs approval: %s\napprove? [y/n] [comment]: ", a.TaskName, a.Id, a.Message)
+			line, err := in.ReadString('\n')
+			if err != nil {
+				glog.Error("could not read the approval ", err)
+				return
+			}
+			answer := strings.SplitN(strings.TrimSpace(line), " ", 2)
+			comment := ""
+			if len(answer) > 1 {
+				comment = answer[1]
+			}
+			approve := strings.HasPrefix(strings.ToLower(answer[0]), "y")
+			if err := decide(a.Id, approve, user, comment); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// approve or reject a gate waiting in an agent that is already running - with the api token if the
+// agent has auth
+func sendDecision(agent, token, id string, approve bool, user, comment string) error {
+	path := "/api/approvals/reject"
+	if approve {
+		path = "/api/approvals/approve"
+	}
+
+	b, err := json.Marshal(ApprovalInstruction{Id: id, User: user, Comment: comment})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(agent, "/")+rootFolder+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		er := ErrorResponse{}
+		if json.Unmarshal(body, &er) == nil && er.Error.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, er.Error.Message)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
 }
 
 // serve as an rpc
@@ -21,13 +110,70 @@ func main() {
 	env := flag.String("env", "local", "any environment flag that filters the presented flows")
 	host := flag.String("host", ":3000", "the host to bind to")
 	flowId := flag.String("exec", "", "the flow id to execture directly from the command line")
+	flowsFile := flag.String("flows", "", "a json or yaml project definition to load instead of the compiled in flows")
+	approveId := flag.String("approve", "", "approve the gate with this approval id in the running agent")
+	rejectId := flag.String("reject", "", "reject the gate with this approval id in the running agent")
+	user := flag.String("user", os.Getenv("USER"), "who is approving or rejecting")
+	comment := flag.String("comment", "", "why it was approved or rejected")
+	agent := flag.String("agent", "http://localhost:3000", "the running agent to send approvals to")
+	history := flag.String("history", "history", "the folder the runs of each flow are kept in")
+	keepRuns := flag.Int("keep-runs", 200, "the most runs kept in the history of each flow - the oldest are deleted, zero keeps them all")
+	maxRuns := flag.Int("max-runs", 0, "the most runs going at once across all flows - overrides the project, zero keeps its limit")
+	maxFlowRuns := flag.Int("max-flow-runs", 0, "the most runs of each flow going at once - for flows without a limit of their own")
+	authFile := flag.String("auth", "", "a json or yaml file of the api tokens, htpasswd file and user roles - without it the api is open")
+	cors := flag.String("cors", "", "comma separated origins browsers may call the api from - overrides the auth file, * for any")
+	token := flag.String("token", os.Getenv("FLOE_TOKEN"), "the api token to send approvals to the running agent with")
 
 	flag.Parse()
 
-	setup(*env, customfloe.GetFlows)
+	if *approveId != "" || *rejectId != "" {
+		id := *approveId
+		if id == "" {
+			id = *rejectId
+		}
+		if err := sendDecision(*agent, *token, id, *approveId != "", *user, *comment); err != nil {
+			fmt.Fprintln(os.Stderr, "could not send the decision:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *authFile != "" {
+		a, err := auth.LoadConfig(*authFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "could not load auth:", err)
+			os.Exit(1)
+		}
+		agentAuth = a
+		corsOrigins = a.CORS
+	}
+	if *cors != "" {
+		corsOrigins = strings.Split(*cors, ",")
+	}
+
+	getFlows := customfloe.GetFlows
+	if *flowsFile != "" {
+		getFlows = loadFlows(*flowsFile)
+	}
+
+	setup(*env, getFlows)
+
+	if *maxRuns > 0 {
+		project.MaxRuns = *maxRuns
+	}
+	for _, l := range project.FlowLaunchers {
+		if l.MaxRuns == 0 {
+			l.MaxRuns = *maxFlowRuns
+		}
+	}
+
+	project.KeepRuns = *keepRuns
+	if err := project.LoadHistory(f.NewFileRunStore(*history)); err != nil {
+		fmt.Fprintln(os.Stderr, "could not load all the run history:", err)
+	}
 
 	if *flowId != "" {
-		runCommandLine(*flowId)
+		runCommandLine(*flowId, *user)
 		return
 	}
 
@@ -37,3 +183,16 @@ func main() {
 	// 	runAgent()
 	// }
 }
+
+// get the project from a definition file
+func loadFlows(path string) GetFlowsFunc {
+	return func(env string) *f.Project {
+		p, err := loader.LoadProject(path, env)
+		if err != nil {
+			glog.Error("could not load flows ", err)
+			fmt.Fprintln(os.Stderr, "could not load flows:", err)
+			os.Exit(1)
+		}
+		return p
+	}
+}
@@ -12,6 +12,9 @@ import (
 // the global project
 var project *f.Project
 
+// the queue every run started through the agent waits in until there is room for it
+var queue *f.RunQueue
+
 type GetFlowsFunc func(env string) *f.Project
 
 // load in our specific flows
@@ -19,25 +22,90 @@ func setup(env string, getfloesFunc GetFlowsFunc) {
 	flag.Parse()
 	glog.Info("Floe starting")
 	project = getfloesFunc(env)
+	queue = f.NewRunQueue(project)
+
+	// report any broken flows up front - they will refuse to start
+	for id, issues := range project.Validate() {
+		for _, vi := range issues {
+			glog.Warning("flow ", id, " ", vi)
+		}
+	}
+
 	project.RunTriggers()
 }
 
-// start a particular flow -
-func start(flowId string, delay time.Duration, endChan chan *f.Params) (*f.FlowLauncher, error) {
+// queue a run of a particular flow for the user - it starts once the project and the flow have room for it
+func start(flowId, user string, delay time.Duration, endChan chan *f.Params) (f.QueuedRun, error) {
 	launcher, ok := project.FlowLaunchers[flowId]
 
 	if !ok {
 		glog.Error("cant start - flow not found ", flowId)
-		return nil, errors.New("flow not found")
+		return f.QueuedRun{}, errors.New("flow not found")
+	}
+
+	if issues := launcher.Validate(); issues.HasErrors() {
+		glog.Error("cant start - flow is not valid ", flowId, " ", issues.Error())
+		return f.QueuedRun{}, &invalidFlowError{issues}
 	}
 
 	glog.Infoln("executing:", flowId)
 
-	go launcher.Start(delay, endChan)
+	qr, err := queue.Submit(flowId, user, delay, nil, endChan)
+	if err != nil {
+		return qr, err
+	}
 
-	glog.Infoln("started:", flowId)
+	if qr.RunId != "" {
+		glog.Infoln("started:", flowId, "run", qr.RunId)
+	} else {
+		glog.Infoln("queued:", flowId, qr.Id, "at", qr.Position)
+	}
 
-	return launcher, nil
+	return qr, nil
+}
+
+// start a flow in debug mode - it is stepped through the debugger rather than automatically
+func startDebug(flowId, user string, paused bool, breakpoints []string) (*f.Run, error) {
+	launcher, ok := project.FlowLaunchers[flowId]
+
+	if !ok {
+		glog.Error("cant debug - flow not found ", flowId)
+		return nil, errors.New("flow not found")
+	}
+
+	if issues := launcher.Validate(); issues.HasErrors() {
+		glog.Error("cant debug - flow is not valid ", flowId, " ", issues.Error())
+		return nil, &invalidFlowError{issues}
+	}
+
+	glog.Infoln("debugging:", flowId)
+
+	return launcher.StartDebug(user, paused, breakpoints, nil, nil), nil
+}
+
+// the debugger of the run of the flow - a flow can have more than one debug run at once
+func debugger(flowId, runId string) (*f.Debugger, error) {
+	launcher, ok := project.FlowLaunchers[flowId]
+
+	if !ok {
+		return nil, errors.New("flow not found")
+	}
+
+	d := launcher.Debugger(runId)
+	if d == nil {
+		return nil, errors.New("no run in progress was started in debug mode: " + runId)
+	}
+
+	return d, nil
+}
+
+// returned when a flow fails validation
+type invalidFlowError struct {
+	Issues f.ValidationIssues
+}
+
+func (e *invalidFlowError) Error() string {
+	return "flow is not valid: " + e.Issues.Error()
 }
 
 // stop any flow in progress
@@ -55,23 +123,64 @@ func stop(flowId string) error {
 	return nil
 }
 
-// start the flow and return - expecting some other thing is looking at statuses (e.g. a ajax request)
-func exec_async(flowId string, delay time.Duration) (*f.FlowLauncher, error) {
-	flow, err := start(flowId, delay, nil)
+// stop one run of a flow
+func stopRun(flowId, runId string) error {
+	flow, ok := project.FlowLaunchers[flowId]
 
-	if err != nil {
-		return nil, err
+	if !ok {
+		glog.Error("cant stop - flow not found ", flowId)
+		return errors.New("flow not found")
+	}
+
+	return flow.StopRun(runId)
+}
+
+// take a run out of the queue before it starts
+func cancelQueued(queueId string) (f.QueuedRun, error) {
+	return queue.Cancel(queueId)
+}
+
+// the flow of the queued run - empty if it is not in the queue
+func queuedFlow(queueId string) string {
+	for _, qr := range queue.Queued() {
+		if qr.Id == queueId {
+			return qr.FlowId
+		}
 	}
+	return ""
+}
+
+// the flow of the gate waiting for a decision - empty if it is not waiting
+func approvalFlow(approvalId string) string {
+	for _, a := range f.Approvals.Pending() {
+		if a.Id == approvalId {
+			return f.MakeID(a.FlowName)
+		}
+	}
+	return ""
+}
+
+// approve or reject a gate that is waiting
+func decide(approvalId string, approve bool, user, comment string) error {
+	glog.Infoln("approval", approvalId, "approved:", approve, "by", user)
+	return f.Approvals.Decide(approvalId, f.Decision{
+		Approved: approve,
+		User:     user,
+		Comment:  comment,
+	})
+}
 
-	return flow, nil
+// queue the flow and return - expecting some other thing is looking at statuses (e.g. a ajax request)
+func exec_async(flowId, user string, delay time.Duration) (f.QueuedRun, error) {
+	return start(flowId, user, delay, nil)
 }
 
 // start the flow but block waiting for the result
-func exec(flowId string, delay time.Duration) error {
+func exec(flowId, user string, delay time.Duration) error {
 
 	ec := make(chan *f.Params)
 
-	_, err := start(flowId, delay, ec)
+	_, err := start(flowId, user, delay, ec)
 
 	if err != nil {
 		return err
@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// what a user can do - each role can do everything the roles below it can
+type Role int
+
+const (
+	NONE     Role = iota
+	VIEWER        // see flows, runs and their output
+	OPERATOR      // start, stop and debug runs and decide gates
+	ADMIN         // delete runs
+)
+
+var roleNames = map[string]Role{
+	"none":     NONE,
+	"viewer":   VIEWER,
+	"operator": OPERATOR,
+	"admin":    ADMIN,
+}
+
+func ParseRole(s string) (Role, error) {
+	r, ok := roleNames[strings.ToLower(s)]
+	if !ok {
+		return NONE, errors.New("unknown role: " + s)
+	}
+	return r, nil
+}
+
+func (r Role) String() string {
+	for n, nr := range roleNames {
+		if nr == r {
+			return n
+		}
+	}
+	return "unknown"
+}
+
+// someone using the agent - their role can be different for some flows
+type User struct {
+	Name  string
+	Role  Role
+	Flows map[string]Role // the role on these flows instead of Role
+}
+
+// the users role on the flow - their own role if the flow is empty or has no role of its own
+func (u *User) RoleFor(flowId string) Role {
+	if r, ok := u.Flows[flowId]; ok && flowId != "" {
+		return r
+	}
+	return u.Role
+}
+
+// the highest role the user has on any flow
+func (u *User) MaxRole() Role {
+	max := u.Role
+	for _, r := range u.Flows {
+		if r > max {
+			max = r
+		}
+	}
+	return max
+}
+
+// can the user act with the role on the flow
+func (u *User) Can(flowId string, r Role) bool {
+	return u.RoleFor(flowId) >= r
+}
+
+var (
+	ErrNoCredentials  = errors.New("no credentials")
+	ErrBadCredentials = errors.New("bad credentials")
+)
+
+// An Authenticator works out who made the request - it returns ErrNoCredentials if the request has no
+// credentials it understands so the next one can have a go
+type Authenticator interface {
+	Authenticate(req *http.Request) (*User, error)
+}
+
+// TokenAuth knows users by a static api token sent as "Authorization: Bearer <token>" - or as the
+// access_token query param for clients that can not set headers e.g. an EventSource
+type TokenAuth struct {
+	tokens map[string]*User
+}
+
+func NewTokenAuth() *TokenAuth {
+	return &TokenAuth{tokens: map[string]*User{}}
+}
+
+func (a *TokenAuth) Add(token string, u *User) {
+	a.tokens[token] = u
+}
+
+func (a *TokenAuth) Authenticate(req *http.Request) (*User, error) {
+	token := req.URL.Query().Get("access_token")
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	// look at every token so the time taken does not give away how close it was
+	var found *User
+	for t, u := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = u
+		}
+	}
+	if found == nil {
+		return nil, ErrBadCredentials
+	}
+	return found, nil
+}
+
+// BasicAuth checks http basic credentials against the password hashes from a htpasswd file - bcrypt,
+// apr1 (md5), {SHA} or plain text. Users get their role from Users or DefaultRole if they are not in it.
+type BasicAuth struct {
+	Realm       string
+	Users       map[string]*User
+	DefaultRole Role
+	hashes      map[string]string
+}
+
+func NewBasicAuth(hashes map[string]string) *BasicAuth {
+	return &BasicAuth{
+		Realm:       "floe",
+		Users:       map[string]*User{},
+		DefaultRole: VIEWER,
+		hashes:      hashes,
+	}
+}
+
+func (a *BasicAuth) Authenticate(req *http.Request) (*User, error) {
+	name, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	hash, ok := a.hashes[name]
+	if !ok || !checkPassword(hash, password) {
+		return nil, ErrBadCredentials
+	}
+	if u, ok := a.Users[name]; ok {
+		return u, nil
+	}
+	return &User{Name: name, Role: a.DefaultRole}, nil
+}
+
+func checkPassword(hash, password string) bool {
+	want := password
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, apr1Magic):
+		salt := strings.SplitN(hash[len(apr1Magic):], "$", 2)[0]
+		want = apr1(password, salt)
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		want = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, "$"):
+		return false // a scheme we don't know - ReadHtpasswd rejects these
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(want)) == 1
+}
+
+func isBcrypt(hash string) bool {
+	for _, p := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, p) {
+			return true
+		}
+	}
+	return false
+}
+
+const apr1Magic = "$apr1$"
+
+// the apache variant of the md5 crypt hash of the password - the default of htpasswd
+func apr1(password, salt string) string {
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw, s := []byte(password), []byte(salt)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(s)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(apr1Magic))
+	h.Write(s)
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(altSum)
+		} else {
+			h.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	// stretch it
+	for i := 0; i < 1000; i++ {
+		r := md5.New()
+		if i&1 == 1 {
+			r.Write(pw)
+		} else {
+			r.Write(sum)
+		}
+		if i%3 != 0 {
+			r.Write(s)
+		}
+		if i%7 != 0 {
+			r.Write(pw)
+		}
+		if i&1 == 1 {
+			r.Write(sum)
+		} else {
+			r.Write(pw)
+		}
+		sum = r.Sum(nil)
+	}
+
+	out := []byte{}
+	enc := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	enc(sum[0], sum[6], sum[12], 4)
+	enc(sum[1], sum[7], sum[13], 4)
+	enc(sum[2], sum[8], sum[14], 4)
+	enc(sum[3], sum[9], sum[15], 4)
+	enc(sum[4], sum[10], sum[5], 4)
+	enc(0, 0, sum[11], 2)
+
+	return apr1Magic + salt + "$" + string(out)
+}
+
+// read the user:hash lines of a htpasswd file - blank lines and # comments are skipped. A hash that
+// starts with $ but is not bcrypt or apr1 (e.g. sha-crypt) is an error rather than a plain text password.
+func ReadHtpasswd(r io.Reader) (map[string]string, error) {
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("bad htpasswd line %d", n) // not the line - it has the hash in it
+		}
+		if h := parts[1]; strings.HasPrefix(h, "$") && !isBcrypt(h) && !strings.HasPrefix(h, apr1Magic) {
+			return nil, fmt.Errorf("unsupported password hash on htpasswd line %d", n)
+		}
+		hashes[parts[0]] = parts[1]
+	}
+	return hashes, scanner.Err()
+}
+
+func LoadHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadHtpasswd(f)
+}
+
+// Auth tries each authenticator in turn
+type Auth struct {
+	Authenticators []Authenticator
+	Realm          string   // sent with a 401 when basic auth is on
+	CORS           []string // the origins browsers may call the api from - * for any
+}
+
+// the user that made the request - ErrNoCredentials if it had none any authenticator understood
+func (a *Auth) Authenticate(req *http.Request) (*User, error) {
+	for _, au := range a.Authenticators {
+		u, err := au.Authenticate(req)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return u, err
+	}
+	return nil, ErrNoCredentials
+}
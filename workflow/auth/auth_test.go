@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const htpasswd = `# users
+alice:$2y$04$2ypylM9ucA0DZ77oxRFRuudl9OW/3oYsN3WYguTPInwAiSgxRkGz6
+bob:{SHA}GpHWL3ymc5liWkNopqtdSjuqYHM=
+
+carol:pw
+erin:$apr1$abcdefgh$5VEbMkemELfbhC5ck.U.z1
+`
+
+const config = `
+htpasswd: htpasswd
+users:
+  - {name: alice, role: admin}
+  - {name: bob, role: viewer, flows: {deploy: operator}}
+tokens:
+  - {token: s3cret, user: ci, role: operator}
+cors: ["https://dash.example.com"]
+`
+
+func loadTestAuth(t *testing.T) *Auth {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "htpasswd"), []byte(htpasswd), 0666); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "auth.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0666); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func Test_Authenticate(t *testing.T) {
+	a := loadTestAuth(t)
+
+	basic := func(user, pw string) (*User, error) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(user, pw)
+		return a.Authenticate(req)
+	}
+
+	// bcrypt, sha, plain text and apr1
+	for _, name := range []string{"alice", "bob", "carol", "erin"} {
+		u, err := basic(name, "pw")
+		if err != nil || u.Name != name {
+			t.Error("could not authenticate", name, err)
+		}
+		if _, err := basic(name, "nope"); err != ErrBadCredentials {
+			t.Error("expected a bad password to fail for", name, err)
+		}
+	}
+	if _, err := basic("dave", "pw"); err != ErrBadCredentials {
+		t.Error("expected an unknown user to fail", err)
+	}
+
+	if u, _ := basic("carol", "pw"); u.Role != VIEWER {
+		t.Error("expected an unlisted user to get the default role", u.Role)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	if u, err := a.Authenticate(req); err != nil || u.Name != "ci" || u.Role != OPERATOR {
+		t.Error("token not accepted", u, err)
+	}
+	req = httptest.NewRequest("GET", "/?access_token=s3cret", nil)
+	if u, err := a.Authenticate(req); err != nil || u.Name != "ci" {
+		t.Error("query token not accepted", u, err)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer nope")
+	if _, err := a.Authenticate(req); err != ErrBadCredentials {
+		t.Error("expected a bad token to fail", err)
+	}
+
+	if _, err := a.Authenticate(httptest.NewRequest("GET", "/", nil)); err != ErrNoCredentials {
+		t.Error("expected no credentials", err)
+	}
+
+	if len(a.CORS) != 1 || a.CORS[0] != "https://dash.example.com" {
+		t.Error("cors origins not loaded", a.CORS)
+	}
+}
+
+func Test_Roles(t *testing.T) {
+	a := loadTestAuth(t)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("bob", "pw")
+	bob, err := a.Authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bob.Can("build", VIEWER) || bob.Can("build", OPERATOR) || bob.Can("", OPERATOR) {
+		t.Error("bob should only view build")
+	}
+	if !bob.Can("deploy", OPERATOR) || bob.Can("deploy", ADMIN) {
+		t.Error("bob should operate deploy")
+	}
+	if bob.MaxRole() != OPERATOR {
+		t.Error("bad max role", bob.MaxRole())
+	}
+
+	if r, err := ParseRole("Operator"); err != nil || r != OPERATOR || r.String() != "operator" {
+		t.Error("bad role", r, err)
+	}
+	if _, err := ParseRole("god"); err == nil {
+		t.Error("expected an unknown role to fail")
+	}
+}
+
+func Test_BuildErrors(t *testing.T) {
+	bad := []*Config{
+		{},
+		{Tokens: []*TokenDef{{Token: "t"}}},
+		{Tokens: []*TokenDef{{Token: "t", User: "u", Role: "god"}}},
+		{Tokens: []*TokenDef{{Token: "t", User: "u", Flows: map[string]string{"a": "god"}}}},
+		{Users: []*UserDef{{Name: "u"}}},
+		{Htpasswd: "/no/such/file"},
+	}
+	for i, cfg := range bad {
+		if _, err := Build(cfg); err == nil {
+			t.Error("expected an error for config", i)
+		}
+	}
+
+	if _, err := ReadHtpasswd(strings.NewReader("nocolon\n")); err == nil {
+		t.Error("expected a bad htpasswd line to fail")
+	}
+	for _, h := range []string{"$1$salt$hash", "$5$salt$hash", "$6$rounds=5000$salt$hash"} {
+		if _, err := ReadHtpasswd(strings.NewReader("u:" + h + "\n")); err == nil {
+			t.Error("expected an unsupported hash to fail ", h)
+		}
+	}
+}
+
+func Test_Apr1(t *testing.T) {
+	// made with openssl passwd -apr1
+	for pw, hash := range map[string]string{
+		"pw":     "$apr1$x$c1lfMpyyDm.graHWYyv3p/",
+		"secret": "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0",
+	} {
+		if !checkPassword(hash, pw) {
+			t.Error("apr1 hash not matched ", hash)
+		}
+		if checkPassword(hash, pw+"x") {
+			t.Error("apr1 hash matched the wrong password ", hash)
+		}
+	}
+	if checkPassword("$5$salt$hash", "$5$salt$hash") {
+		t.Error("an unknown scheme should never match - even as plain text")
+	}
+}
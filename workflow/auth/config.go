@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the auth definition file - e.g. in yaml
+//
+//	htpasswd: /etc/floe/htpasswd
+//	default_role: viewer
+//	users:
+//	  - {name: alice, role: admin}
+//	  - {name: bob, role: viewer, flows: {deploy: operator}}
+//	tokens:
+//	  - {token: s3cret, user: ci, role: operator}
+//	cors: ["https://dash.example.com"]
+type Config struct {
+	Htpasswd    string      `json:"htpasswd" yaml:"htpasswd"`         // relative to the config file
+	DefaultRole string      `json:"default_role" yaml:"default_role"` // for htpasswd users not in users - viewer if not set
+	Users       []*UserDef  `json:"users" yaml:"users"`
+	Tokens      []*TokenDef `json:"tokens" yaml:"tokens"`
+	CORS        []string    `json:"cors" yaml:"cors"` // the origins browsers may call the api from
+}
+
+type UserDef struct {
+	Name  string            `json:"name" yaml:"name"`
+	Role  string            `json:"role" yaml:"role"`
+	Flows map[string]string `json:"flows" yaml:"flows"` // role by flow id
+}
+
+type TokenDef struct {
+	Token string            `json:"token" yaml:"token"`
+	User  string            `json:"user" yaml:"user"` // recorded on the runs started with the token
+	Role  string            `json:"role" yaml:"role"`
+	Flows map[string]string `json:"flows" yaml:"flows"`
+}
+
+// load the auth config from a .json, .yaml or .yml file
+func LoadConfig(path string) (*Auth, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(body, cfg)
+	default:
+		err = json.Unmarshal(body, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if cfg.Htpasswd != "" && !filepath.IsAbs(cfg.Htpasswd) {
+		cfg.Htpasswd = filepath.Join(filepath.Dir(path), cfg.Htpasswd)
+	}
+
+	a, err := Build(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return a, nil
+}
+
+// make the authenticators from the config - tokens are tried before basic auth
+func Build(cfg *Config) (*Auth, error) {
+	a := &Auth{CORS: cfg.CORS}
+
+	if len(cfg.Tokens) > 0 {
+		ta := NewTokenAuth()
+		for _, td := range cfg.Tokens {
+			if td.Token == "" || td.User == "" {
+				return nil, fmt.Errorf("tokens need a token and a user")
+			}
+			u, err := user(td.User, td.Role, td.Flows)
+			if err != nil {
+				return nil, err
+			}
+			ta.Add(td.Token, u)
+		}
+		a.Authenticators = append(a.Authenticators, ta)
+	}
+
+	if cfg.Htpasswd != "" {
+		hashes, err := LoadHtpasswd(cfg.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		ba := NewBasicAuth(hashes)
+		if cfg.DefaultRole != "" {
+			if ba.DefaultRole, err = ParseRole(cfg.DefaultRole); err != nil {
+				return nil, err
+			}
+		}
+		for _, ud := range cfg.Users {
+			u, err := user(ud.Name, ud.Role, ud.Flows)
+			if err != nil {
+				return nil, err
+			}
+			ba.Users[ud.Name] = u
+		}
+		a.Realm = ba.Realm
+		a.Authenticators = append(a.Authenticators, ba)
+	} else if len(cfg.Users) > 0 {
+		return nil, fmt.Errorf("users need a htpasswd file")
+	}
+
+	if len(a.Authenticators) == 0 {
+		return nil, fmt.Errorf("no tokens or htpasswd file")
+	}
+	return a, nil
+}
+
+func user(name, role string, flows map[string]string) (*User, error) {
+	u := &User{Name: name, Role: VIEWER, Flows: map[string]Role{}}
+	var err error
+	if role != "" {
+		if u.Role, err = ParseRole(role); err != nil {
+			return nil, fmt.Errorf("user %s: %v", name, err)
+		}
+	}
+	for flowId, fr := range flows {
+		if u.Flows[flowId], err = ParseRole(fr); err != nil {
+			return nil, fmt.Errorf("user %s flow %s: %v", name, flowId, err)
+		}
+	}
+	return u, nil
+}
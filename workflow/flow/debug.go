@@ -0,0 +1,219 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// a node that is waiting to be released by the debugger
+type PendingStep struct {
+	Id         string
+	ThreadId   int
+	TaskId     string
+	TaskName   string
+	Breakpoint bool // stopped at a breakpoint rather than because the run is paused
+	Since      time.Time
+	Params     *Params // what the node will execute with - can be edited until it is released
+	release    chan struct{}
+}
+
+// the state of the debugger for the api
+type DebugState struct {
+	Paused      bool
+	Breakpoints []string
+	Pending     []*PendingStep
+}
+
+// A Debugger holds nodes before they execute. While it is paused every node waits and otherwise
+// only nodes with a breakpoint wait. Each waiting node is a PendingStep that can be inspected,
+// have its props edited and then be released - one at a time with Step or by id with Release.
+type Debugger struct {
+	lock        sync.Mutex
+	paused      bool
+	breakpoints map[string]bool
+	pending     map[string]*PendingStep
+	seq         int
+}
+
+func NewDebugger(paused bool, breakpoints []string) *Debugger {
+	d := &Debugger{
+		paused:      paused,
+		breakpoints: map[string]bool{},
+		pending:     map[string]*PendingStep{},
+	}
+	for _, b := range breakpoints {
+		d.breakpoints[MakeID(b)] = true
+	}
+	return d
+}
+
+func (d *Debugger) SetBreakpoint(nodeId string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.breakpoints[MakeID(nodeId)] = true
+}
+
+func (d *Debugger) ClearBreakpoint(nodeId string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.breakpoints, MakeID(nodeId))
+}
+
+// stop every node before it executes
+func (d *Debugger) Pause() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.paused = true
+}
+
+// stop pausing and release everything that is waiting - breakpoints still stop later nodes
+func (d *Debugger) Continue() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.paused = false
+	for id, ps := range d.pending {
+		close(ps.release)
+		delete(d.pending, id)
+	}
+}
+
+// a snapshot of the debugger - the pending params are copies so they can be marshalled safely
+func (d *Debugger) State() DebugState {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	st := DebugState{
+		Paused:      d.paused,
+		Breakpoints: []string{},
+		Pending:     []*PendingStep{},
+	}
+	for b := range d.breakpoints {
+		st.Breakpoints = append(st.Breakpoints, b)
+	}
+	sort.Strings(st.Breakpoints)
+
+	for _, ps := range d.sortedPending() {
+		cp := *ps
+		cp.Params = copyParams(ps.Params)
+		st.Pending = append(st.Pending, &cp)
+	}
+	return st
+}
+
+// release the node that has been waiting longest - returns its id
+func (d *Debugger) Step() (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	pend := d.sortedPending()
+	if len(pend) == 0 {
+		return "", errors.New("no nodes are waiting")
+	}
+	d.release(pend[0])
+	return pend[0].Id, nil
+}
+
+// release the pending step with this id
+func (d *Debugger) Release(stepId string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	ps, ok := d.pending[stepId]
+	if !ok {
+		return errors.New("no pending step: " + stepId)
+	}
+	d.release(ps)
+	return nil
+}
+
+// change the props the pending step will execute with - keys in del are removed
+func (d *Debugger) EditProps(stepId string, set Props, del []string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	ps, ok := d.pending[stepId]
+	if !ok {
+		return errors.New("no pending step: " + stepId)
+	}
+	for k, v := range set {
+		ps.Params.Props[k] = v
+	}
+	for _, k := range del {
+		delete(ps.Params.Props, k)
+	}
+	glog.Info("debugger edited props of ", stepId)
+	return nil
+}
+
+// must hold the lock
+func (d *Debugger) release(ps *PendingStep) {
+	glog.Info("debugger released ", ps.Id)
+	close(ps.release)
+	delete(d.pending, ps.Id)
+}
+
+// must hold the lock
+func (d *Debugger) sortedPending() []*PendingStep {
+	pend := make([]*PendingStep, 0, len(d.pending))
+	for _, ps := range d.pending {
+		pend = append(pend, ps)
+	}
+	sort.Slice(pend, func(i, j int) bool {
+		return pend[i].Since.Before(pend[j].Since) ||
+			(pend[i].Since.Equal(pend[j].Since) && pend[i].Id < pend[j].Id)
+	})
+	return pend
+}
+
+// called by a node before it executes - blocks while the node is held, returns false if the
+// flow was stopped while waiting. Edits are made to p so the node sees them.
+func (d *Debugger) wait(ctx context.Context, p *Params) bool {
+	d.lock.Lock()
+	bp := d.breakpoints[p.TaskId]
+	if !d.paused && !bp {
+		d.lock.Unlock()
+		return true
+	}
+
+	d.seq++
+	ps := &PendingStep{
+		Id:         fmt.Sprintf("%d-%s-%d", p.ThreadId, p.TaskId, d.seq),
+		ThreadId:   p.ThreadId,
+		TaskId:     p.TaskId,
+		TaskName:   p.TaskName,
+		Breakpoint: bp,
+		Since:      time.Now(),
+		Params:     p,
+		release:    make(chan struct{}),
+	}
+	d.pending[ps.Id] = ps
+	d.lock.Unlock()
+
+	glog.Info("debugger holding ", ps.Id)
+
+	select {
+	case <-ps.release:
+		return true
+	case <-ctx.Done():
+		d.lock.Lock()
+		delete(d.pending, ps.Id)
+		d.lock.Unlock()
+		return false
+	}
+}
+
+func copyParams(p *Params) *Params {
+	cp := &Params{}
+	*cp = *p
+	cp.Props = Props{}
+	for k, v := range p.Props {
+		cp.Props[k] = v
+	}
+	return cp
+}
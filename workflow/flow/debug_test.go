@@ -0,0 +1,181 @@
+package flow
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type debugFlow struct {
+	childFlow
+}
+
+func (d *debugFlow) FlowFunc(threadId int) *Workflow {
+	w := MakeWorkflow()
+	a := w.MakeTaskNode("a", nopTask{})
+	b := w.MakeTaskNode("b", nopTask{})
+	a.AddNext(SUCCESS, b)
+	w.SetStart(a)
+	w.SetEnd(b)
+	return w
+}
+
+func debugLauncher(t *testing.T) *FlowLauncher {
+	df := &debugFlow{childFlow{dir: filepath.Join(t.TempDir())}}
+	df.Init("debug")
+	return MakeFlowLauncher(df, 1, nil, nil)
+}
+
+// wait for the only pending step
+func waitPending(t *testing.T, d *Debugger, taskId string) *PendingStep {
+	for i := 0; i < 1000; i++ {
+		if p := d.State().Pending; len(p) > 0 {
+			if len(p) != 1 || p[0].TaskId != taskId {
+				t.Fatal("expected only", taskId, "to be pending", p)
+			}
+			return p[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("nothing pending")
+	return nil
+}
+
+func Test_DebugPausedStepAndEdit(t *testing.T) {
+	l := debugLauncher(t)
+	ec := make(chan *Params, 1)
+	r := l.StartDebug("", true, nil, nil, ec)
+	d := l.Debugger(r.Id)
+	if d == nil || d != r.Debugger() {
+		t.Fatal("debugger not found by run id")
+	}
+
+	ps := waitPending(t, d, "a")
+	if err := d.EditProps(ps.Id, Props{"colour": "red"}, []string{"path"}); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := d.Step(); err != nil || id != ps.Id {
+		t.Fatal("step should release a", id, err)
+	}
+
+	ps = waitPending(t, d, "b")
+	if ps.Params.Props["colour"] != "red" {
+		t.Error("edited props not used", ps.Params.Props)
+	}
+	if _, ok := ps.Params.Props["path"]; ok {
+		t.Error("deleted prop still there")
+	}
+
+	if err := d.Release("nope"); err == nil {
+		t.Error("expected an error releasing an unknown step")
+	}
+	d.Continue()
+
+	select {
+	case res := <-ec:
+		if res.Status != SUCCESS {
+			t.Error("bad end status", res.Status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not finish")
+	}
+}
+
+func Test_DebugBreakpoint(t *testing.T) {
+	l := debugLauncher(t)
+	ec := make(chan *Params, 1)
+	d := l.StartDebug("", false, []string{"b"}, nil, ec).Debugger()
+
+	ps := waitPending(t, d, "b")
+	if !ps.Breakpoint {
+		t.Error("should be stopped at a breakpoint")
+	}
+	if err := d.Release(ps.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ec:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not finish")
+	}
+}
+
+type debugLoopFlow struct {
+	childFlow
+}
+
+func (d *debugLoopFlow) FlowFunc(threadId int) *Workflow {
+	w := MakeWorkflow()
+	a := w.MakeTaskNode("a", nopTask{})
+	l, _ := w.MakeLoopNode("l", LoopSpec{Count: 1})
+	b := w.MakeTaskNode("b", nopTask{})
+	e := w.MakeTaskNode("e", nopTask{})
+	a.AddNext(SUCCESS, l)
+	l.AddNext(LOOP, b)
+	b.AddNext(SUCCESS, l)
+	l.AddNext(SUCCESS, e)
+	w.SetStart(a)
+	w.SetEnd(e)
+	return w
+}
+
+func Test_DebugBreakpointOnLoop(t *testing.T) {
+	df := &debugLoopFlow{childFlow{dir: t.TempDir()}}
+	df.Init("debug-loop")
+	l := MakeFlowLauncher(df, 1, nil, nil)
+	ec := make(chan *Params, 1)
+	d := l.StartDebug("", false, []string{"l"}, nil, ec).Debugger()
+
+	// on the way in and on the way back round from the body
+	for i := 0; i < 2; i++ {
+		ps := waitPending(t, d, "l")
+		if err := d.Release(ps.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case res := <-ec:
+		if res.Status != SUCCESS {
+			t.Error("bad end status", res.Status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not finish")
+	}
+}
+
+func Test_DebugRunsById(t *testing.T) {
+	l := debugLauncher(t)
+	ec1, ec2 := make(chan *Params, 1), make(chan *Params, 1)
+	r1 := l.StartDebug("", true, nil, nil, ec1)
+	r2 := l.StartDebug("", true, nil, nil, ec2)
+
+	d1, d2 := l.Debugger(r1.Id), l.Debugger(r2.Id)
+	if d1 == nil || d2 == nil || d1 == d2 {
+		t.Fatal("each run should have its own debugger")
+	}
+	waitPending(t, d1, "a")
+	waitPending(t, d2, "a")
+
+	// carrying on with one run leaves the other where it is
+	d1.Continue()
+	select {
+	case <-ec1:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not finish")
+	}
+	if ps := waitPending(t, d2, "a"); ps.ThreadId != 0 {
+		t.Error("bad pending step", ps)
+	}
+	if l.Debugger(r1.Id) != nil {
+		t.Error("finished run still has a debugger")
+	}
+
+	d2.Continue()
+	select {
+	case <-ec2:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not finish")
+	}
+}
@@ -0,0 +1,192 @@
+package flow
+
+import (
+	"sync"
+	"time"
+)
+
+// the kinds of event
+const (
+	EVENT_RUN_START = "run-start"
+	EVENT_STATUS    = "status" // a task changed status
+	EVENT_RUN_END   = "run-end"
+)
+
+// a change in a run as it happens - for pushing to dashboards
+type Event struct {
+	Seq       int64 // counts up across all the events of the hub - a subscriber only gets the ones it wants so it sees gaps, Dropped says if it missed any
+	Kind      string
+	FlowId    string
+	RunId     string
+	User      string // who started the run
+	At        time.Time
+	TaskId    string // for status events
+	TaskName  string
+	ThreadId  int
+	Status    int
+	Complete  bool
+	Iteration int
+	Response  string
+}
+
+// the events of the runs of one flow or one run - or every run if both ids are empty
+type Subscription struct {
+	C       chan Event
+	FlowId  string
+	RunId   string
+	keep    func(e Event) bool // leaves out more events - nil keeps them all
+	dropped int64
+	lock    sync.Mutex
+}
+
+// how many events were not delivered because the subscriber was not keeping up - or because they
+// were too old to replay when it resumed
+func (s *Subscription) Dropped() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dropped
+}
+
+func (s *Subscription) drop(n int64) {
+	s.lock.Lock()
+	s.dropped += n
+	s.lock.Unlock()
+}
+
+func (s *Subscription) wants(e Event) bool {
+	if s.keep != nil && !s.keep(e) {
+		return false
+	}
+	if s.RunId != "" {
+		return s.RunId == e.RunId
+	}
+	return s.FlowId == "" || s.FlowId == e.FlowId
+}
+
+// the most recent events the hub holds on to for subscribers that resume
+const eventHistory = 1000
+
+// EventHub fans the events of every run out to the subscribers - a subscriber that is not keeping up
+// misses events rather than holding up the runs
+type EventHub struct {
+	subs   map[*Subscription]bool
+	seq    int64
+	recent []Event // the last eventHistory events - oldest first
+	lock   sync.Mutex
+}
+
+// all runs publish here
+var Events = NewEventHub()
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subs: map[*Subscription]bool{},
+	}
+}
+
+// subscribe to the events of a flow or a run - buffer is how many events can wait to be read
+func (h *EventHub) Subscribe(flowId, runId string, buffer int) *Subscription {
+	return h.Resume(flowId, runId, buffer, -1, nil)
+}
+
+// Resume subscribes like Subscribe, but first the events after seq that the hub still holds are put
+// on C - so a client that reconnects with the Seq of the last event it got misses nothing. Events
+// after seq that are too old to replay are counted as dropped, a negative seq replays nothing. keep
+// can leave out more events - nil keeps them all.
+func (h *EventHub) Resume(flowId, runId string, buffer int, seq int64, keep func(e Event) bool) *Subscription {
+	s := &Subscription{
+		FlowId: flowId,
+		RunId:  runId,
+		keep:   keep,
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	replay := []Event{}
+	if seq >= 0 && seq < h.seq {
+		if len(h.recent) > 0 && h.recent[0].Seq > seq+1 {
+			s.dropped = h.recent[0].Seq - seq - 1
+		}
+		for _, e := range h.recent {
+			if e.Seq > seq && s.wants(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	s.C = make(chan Event, buffer+len(replay))
+	for _, e := range replay {
+		s.C <- e
+	}
+	h.subs[s] = true
+	return s
+}
+
+// stop the events - the subscriptions chanel is closed
+func (h *EventHub) Unsubscribe(s *Subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.subs[s] {
+		delete(h.subs, s)
+		close(s.C)
+	}
+}
+
+func (h *EventHub) Publish(e Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.seq++
+	e.Seq = h.seq
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	h.recent = append(h.recent, e)
+	if len(h.recent) > eventHistory {
+		h.recent = h.recent[len(h.recent)-eventHistory:]
+	}
+
+	for s := range h.subs {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.C <- e:
+		default:
+			s.drop(1)
+		}
+	}
+}
+
+// publish that something happened to the whole run
+func (r *Run) publish(kind string) {
+	r.lock.Lock()
+	e := Event{
+		Kind:   kind,
+		FlowId: r.FlowId,
+		RunId:  r.Id,
+		User:   r.User,
+		Status: r.Status,
+	}
+	r.lock.Unlock()
+	Events.Publish(e)
+}
+
+// publish a status change of a task in the run
+func (r *Run) publishStatus(p *Params) {
+	Events.Publish(Event{
+		Kind:      EVENT_STATUS,
+		FlowId:    r.FlowId,
+		RunId:     r.Id,
+		User:      r.User,
+		TaskId:    p.TaskId,
+		TaskName:  p.TaskName,
+		ThreadId:  p.ThreadId,
+		Status:    p.Status,
+		Complete:  p.Complete,
+		Iteration: p.Iteration,
+		Response:  p.Response,
+	})
+}
@@ -0,0 +1,129 @@
+package flow
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Events(t *testing.T) {
+	cf := &childFlow{dir: t.TempDir()}
+	cf.Init("events")
+	l := MakeFlowLauncher(cf, 1, nil, nil)
+
+	all := Events.Subscribe("events", "", 100)
+	defer Events.Unsubscribe(all)
+	other := Events.Subscribe("other", "", 100)
+	defer Events.Unsubscribe(other)
+
+	ec := make(chan *Params, 1)
+	r := l.StartRun(time.Millisecond, Props{"version": "1.0"}, ec)
+	run := Events.Subscribe("", r.Id, 100)
+	defer Events.Unsubscribe(run)
+
+	select {
+	case <-ec:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not end")
+	}
+
+	// read until the end of the run
+	kinds := []string{}
+	var last Event
+	for e := range all.C {
+		if e.RunId != r.Id || e.Seq <= last.Seq {
+			t.Error("bad event", e)
+		}
+		kinds = append(kinds, e.Kind)
+		last = e
+		if e.Kind == EVENT_RUN_END {
+			break
+		}
+	}
+	if kinds[0] != EVENT_RUN_START || last.Status != SUCCESS {
+		t.Error("bad run events", kinds, last)
+	}
+
+	done := false
+	for _, k := range kinds {
+		if k == EVENT_STATUS {
+			done = true
+		}
+	}
+	if !done {
+		t.Error("expected task status events", kinds)
+	}
+
+	select {
+	case e := <-run.C:
+		if e.RunId != r.Id || e.Kind == EVENT_RUN_START {
+			t.Error("expected the run events after subscribing", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("no events for the run")
+	}
+
+	if len(other.C) != 0 {
+		t.Error("got events of another flow")
+	}
+
+	// a subscriber that does not keep up misses events rather than blocking
+	slow := Events.Subscribe("", "", 1)
+	Events.Publish(Event{Kind: EVENT_STATUS})
+	Events.Publish(Event{Kind: EVENT_STATUS})
+	if slow.Dropped() != 1 {
+		t.Error("expected an event to be dropped", slow.Dropped())
+	}
+	Events.Unsubscribe(slow)
+	if _, ok := <-slow.C; !ok {
+		t.Error("expected the buffered event")
+	}
+	if _, ok := <-slow.C; ok {
+		t.Error("expected the chanel to be closed")
+	}
+}
+
+func Test_EventsResume(t *testing.T) {
+	h := NewEventHub()
+	for i := 0; i < eventHistory+10; i++ {
+		flowId := "a"
+		if i%2 == 1 {
+			flowId = "b"
+		}
+		h.Publish(Event{Kind: EVENT_STATUS, FlowId: flowId})
+	}
+
+	// reconnect after the last 6 events - just the events of a
+	s := h.Resume("a", "", 10, eventHistory+4, nil)
+	defer h.Unsubscribe(s)
+	if len(s.C) != 3 || s.Dropped() != 0 {
+		t.Error("expected the 3 missed events of a", len(s.C), s.Dropped())
+	}
+	for want := int64(eventHistory + 5); len(s.C) > 0; want += 2 {
+		if e := <-s.C; e.Seq != want || e.FlowId != "a" {
+			t.Error("bad replayed event", want, e)
+		}
+	}
+
+	// events too old to replay are counted as dropped
+	old := h.Resume("", "", 10, 5, func(e Event) bool { return e.FlowId == "b" })
+	defer h.Unsubscribe(old)
+	if old.Dropped() != 5 || len(old.C) != eventHistory/2 {
+		t.Error("expected the held events of b and the older ones dropped", old.Dropped(), len(old.C))
+	}
+
+	// the filter applies to new events too
+	h.Publish(Event{Kind: EVENT_STATUS, FlowId: "a"})
+	h.Publish(Event{Kind: EVENT_STATUS, FlowId: "b"})
+	if len(old.C) != eventHistory/2+1 {
+		t.Error("expected just the new event of b", len(old.C))
+	}
+
+	// nothing to replay for a client that is up to date - or from before a restart
+	for _, seq := range []int64{eventHistory + 12, eventHistory * 5} {
+		s := h.Resume("", "", 10, seq, nil)
+		if len(s.C) != 0 || s.Dropped() != 0 {
+			t.Error("expected nothing replayed after ", seq, len(s.C), s.Dropped())
+		}
+		h.Unsubscribe(s)
+	}
+}
@@ -0,0 +1,336 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A Condition is a boolean expression over the params of a completed task e.g.
+//
+//	git-trigger-branch == "master" && ExitStatus == 0
+//
+// Identifiers are the Status, ExitStatus, Response, ThreadId and TaskId fields of the params, the
+// status names (SUCCESS, FAIL ...) or else the value of the prop with that name - missing props are "".
+// Values are compared as numbers if both sides are numbers otherwise as strings. Supported operators
+// are == != < > <= >= && || ! and brackets - a value on its own is true unless it is "", "0" or "false".
+type Condition struct {
+	src  string
+	root exprNode
+}
+
+// parse the expression - so errors are found when the flow is built rather than when it runs
+func ParseCondition(src string) (*Condition, error) {
+	p := &exprParser{}
+	if err := p.lex(src); err != nil {
+		return nil, fmt.Errorf("bad expression %q: %v", src, err)
+	}
+
+	root, err := p.or()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos].val)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad expression %q: %v", src, err)
+	}
+
+	return &Condition{src: src, root: root}, nil
+}
+
+func (c *Condition) String() string {
+	return c.src
+}
+
+// is the condition true for these params
+func (c *Condition) Eval(p *Params) bool {
+	return truthy(c.root.eval(p))
+}
+
+type exprNode interface {
+	eval(p *Params) string
+}
+
+type literal string
+
+func (l literal) eval(p *Params) string {
+	return string(l)
+}
+
+type ident string
+
+func (i ident) eval(p *Params) string {
+	switch string(i) {
+	case "Status":
+		return strconv.Itoa(p.Status)
+	case "ExitStatus":
+		return strconv.Itoa(p.ExitStatus)
+	case "Response":
+		return p.Response
+	case "ThreadId":
+		return strconv.Itoa(p.ThreadId)
+	case "TaskId":
+		return p.TaskId
+	case "true", "false":
+		return string(i)
+	}
+	// only the upper case names are statuses - the others are props
+	if s, ok := StatusValues[strings.ToLower(string(i))]; ok && strings.ToUpper(string(i)) == string(i) {
+		return strconv.Itoa(s)
+	}
+	return p.Props[string(i)]
+}
+
+type notNode struct {
+	x exprNode
+}
+
+func (n notNode) eval(p *Params) string {
+	return boolStr(!truthy(n.x.eval(p)))
+}
+
+type binNode struct {
+	op   string
+	l, r exprNode
+}
+
+func (b binNode) eval(p *Params) string {
+	switch b.op {
+	case "&&":
+		return boolStr(truthy(b.l.eval(p)) && truthy(b.r.eval(p)))
+	case "||":
+		return boolStr(truthy(b.l.eval(p)) || truthy(b.r.eval(p)))
+	}
+
+	l, r := b.l.eval(p), b.r.eval(p)
+
+	// numbers if we can
+	lf, lerr := strconv.ParseFloat(l, 64)
+	rf, rerr := strconv.ParseFloat(r, 64)
+	cmp := strings.Compare(l, r)
+	if lerr == nil && rerr == nil {
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+
+	switch b.op {
+	case "==":
+		return boolStr(cmp == 0)
+	case "!=":
+		return boolStr(cmp != 0)
+	case "<":
+		return boolStr(cmp < 0)
+	case ">":
+		return boolStr(cmp > 0)
+	case "<=":
+		return boolStr(cmp <= 0)
+	case ">=":
+		return boolStr(cmp >= 0)
+	}
+	return "false"
+}
+
+func truthy(s string) bool {
+	return s != "" && s != "0" && s != "false"
+}
+
+func boolStr(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+const (
+	tokIdent = iota
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind int
+	val  string
+}
+
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+var exprOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+func isIdentChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+func (p *exprParser) lex(src string) error {
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '"' || r == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return errors.New("unterminated string")
+			}
+			p.toks = append(p.toks, token{tokString, sb.String()})
+			i = j + 1
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			p.toks = append(p.toks, token{tokNumber, string(rs[i:j])})
+			i = j
+
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(rs) && isIdentChar(rs[j]) {
+				j++
+			}
+			p.toks = append(p.toks, token{tokIdent, string(rs[i:j])})
+			i = j
+
+		default:
+			found := false
+			for _, op := range exprOps {
+				if strings.HasPrefix(string(rs[i:]), op) {
+					p.toks = append(p.toks, token{tokOp, op})
+					i += len([]rune(op))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("unexpected %q", string(r))
+			}
+		}
+	}
+	return nil
+}
+
+func (p *exprParser) peekOp(ops ...string) (string, bool) {
+	if p.pos >= len(p.toks) || p.toks[p.pos].kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.toks[p.pos].val == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekOp("||"); !ok {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = binNode{op: "||", l: l, r: r}
+	}
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekOp("&&"); !ok {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = binNode{op: "&&", l: l, r: r}
+	}
+}
+
+func (p *exprParser) not() (exprNode, error) {
+	if _, ok := p.peekOp("!"); ok {
+		p.pos++
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	}
+	return p.cmp()
+}
+
+func (p *exprParser) cmp() (exprNode, error) {
+	l, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.peekOp("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return l, nil
+	}
+	p.pos++
+	r, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	return binNode{op: op, l: l, r: r}, nil
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, errors.New("unexpected end")
+	}
+
+	t := p.toks[p.pos]
+	p.pos++
+
+	switch t.kind {
+	case tokString, tokNumber:
+		return literal(t.val), nil
+	case tokIdent:
+		return ident(t.val), nil
+	}
+
+	if t.val == "(" {
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.peekOp(")"); !ok {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return x, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q", t.val)
+}
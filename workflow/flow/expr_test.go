@@ -0,0 +1,39 @@
+package flow
+
+import "testing"
+
+func Test_Condition(t *testing.T) {
+	p := MakeParams()
+	p.Status = FAIL
+	p.ExitStatus = 2
+	p.Response = "exit status 2"
+	p.Props["git-trigger-branch"] = "master"
+	p.Props[OutputKey("build", "count")] = "10"
+
+	cases := map[string]bool{
+		`git-trigger-branch == "master"`:                    true,
+		`git-trigger-branch != 'master'`:                    false,
+		`ExitStatus == 2 && Status == FAIL`:                 true,
+		`!(ExitStatus == 2) || Response == "exit status 2"`: true,
+		`build.count > 9`:                                   true, // numeric not string compare
+		`build.count <= -1`:                                 false,
+		`missing`:                                           false,
+		`git-trigger-branch`:                                true,
+	}
+	for expr, want := range cases {
+		c, err := ParseCondition(expr)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := c.Eval(p); got != want {
+			t.Errorf("%s gave %v", expr, got)
+		}
+	}
+
+	for _, bad := range []string{"", "a ==", "(a == b", `a == "b`, "a = b", "a b"} {
+		if _, err := ParseCondition(bad); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Feeder supplies the extra props of each thread of a run - so threads of a load test can each use
+// their own data e.g. a distinct test account. Feed is called once per run, before any thread starts,
+// and returns the props for thread ids 0 to threads-1. An error fails the run.
+type Feeder interface {
+	Feed(threads int) ([]Props, error)
+}
+
+// the go hook - called for each thread in order
+type FeederFunc func(threadId int) (Props, error)
+
+func (ff FeederFunc) Feed(threads int) ([]Props, error) {
+	all := make([]Props, threads)
+	for i := range all {
+		p, err := ff(i)
+		if err != nil {
+			return nil, fmt.Errorf("thread %d: %v", i, err)
+		}
+		all[i] = p
+	}
+	return all, nil
+}
+
+// how the rows of a file are handed out to the threads
+const (
+	FEED_SEQUENTIAL  = "sequential"  // row N for thread N - there must be a row for every thread
+	FEED_ROUND_ROBIN = "round-robin" // row N for thread N going back to the first row when they run out
+	FEED_RANDOM      = "random"      // any row for any thread
+)
+
+// A FileFeeder gives each thread a row of a .csv file (the first line names the props) or a .jsonl
+// file (an object on each line). The file is read on every run so it can change between runs.
+type FileFeeder struct {
+	Path  string
+	Order string
+}
+
+func NewFileFeeder(path, order string) (*FileFeeder, error) {
+	if order == "" {
+		order = FEED_SEQUENTIAL
+	}
+	switch order {
+	case FEED_SEQUENTIAL, FEED_ROUND_ROBIN, FEED_RANDOM:
+	default:
+		return nil, errors.New("unknown feed order: " + order)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".jsonl":
+	default:
+		return nil, errors.New("feed file must be .csv or .jsonl: " + path)
+	}
+
+	return &FileFeeder{Path: path, Order: order}, nil
+}
+
+func (ff *FileFeeder) Feed(threads int) ([]Props, error) {
+	rows, err := ff.rows()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("no rows in feed file " + ff.Path)
+	}
+
+	all := make([]Props, threads)
+	switch ff.Order {
+	case FEED_ROUND_ROBIN:
+		for i := range all {
+			all[i] = rows[i%len(rows)]
+		}
+	case FEED_RANDOM:
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for i := range all {
+			all[i] = rows[r.Intn(len(rows))]
+		}
+	default:
+		if len(rows) < threads {
+			return nil, fmt.Errorf("feed file %s has %d rows for %d threads", ff.Path, len(rows), threads)
+		}
+		copy(all, rows)
+	}
+	return all, nil
+}
+
+func (ff *FileFeeder) rows() ([]Props, error) {
+	body, err := ioutil.ReadFile(ff.Path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(filepath.Ext(ff.Path)) == ".csv" {
+		return csvRows(body)
+	}
+	return jsonlRows(body)
+}
+
+// the first record is the header
+func csvRows(body []byte) ([]Props, error) {
+	recs, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, nil
+	}
+
+	head := recs[0]
+	rows := make([]Props, 0, len(recs)-1)
+	for _, rec := range recs[1:] {
+		p := Props{}
+		for i, k := range head {
+			p[strings.TrimSpace(k)] = rec[i]
+		}
+		rows = append(rows, p)
+	}
+	return rows, nil
+}
+
+// blank lines are skipped - values that are not strings are used as they are written in the file
+func jsonlRows(body []byte) ([]Props, error) {
+	rows := []Props{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		obj := map[string]json.RawMessage{}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+
+		p := Props{}
+		for k, raw := range obj {
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				p[k] = s
+				continue
+			}
+			p[k] = string(raw)
+		}
+		rows = append(rows, p)
+	}
+	return rows, scanner.Err()
+}
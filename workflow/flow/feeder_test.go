@@ -0,0 +1,147 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeFeed(t *testing.T, name, body string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_FileFeeder(t *testing.T) {
+	csvPath := writeFeed(t, "accounts.csv", "user, pass\nann,a1\nbob,b2\n")
+	jsonlPath := writeFeed(t, "accounts.jsonl", `{"user": "ann", "pin": 1}`+"\n\n"+`{"user": "bob", "pin": 2}`+"\n")
+
+	for _, path := range []string{csvPath, jsonlPath} {
+		ff, err := NewFileFeeder(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fed, err := ff.Feed(2)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		if fed[0]["user"] != "ann" || fed[1]["user"] != "bob" {
+			t.Error(path, "rows not fed in order", fed)
+		}
+
+		if _, err := ff.Feed(3); err == nil {
+			t.Error(path, "expected sequential to fail with too few rows")
+		}
+	}
+
+	ff, _ := NewFileFeeder(csvPath, FEED_ROUND_ROBIN)
+	fed, err := ff.Feed(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fed[2]["user"] != "ann" || fed[3]["pass"] != "b2" {
+		t.Error("round robin did not wrap", fed)
+	}
+
+	ff, _ = NewFileFeeder(jsonlPath, FEED_RANDOM)
+	fed, err = ff.Feed(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range fed {
+		if p["user"] != "ann" && p["user"] != "bob" {
+			t.Error("random row not from the file", p)
+		}
+		if p["pin"] != "1" && p["pin"] != "2" {
+			t.Error("number not kept as written", p)
+		}
+	}
+
+	if _, err := NewFileFeeder(csvPath, "shuffle"); err == nil {
+		t.Error("expected bad order to fail")
+	}
+	if _, err := NewFileFeeder("accounts.txt", ""); err == nil {
+		t.Error("expected bad file type to fail")
+	}
+}
+
+// records the account prop each thread ran with
+type accountTask struct {
+	lock     sync.Mutex
+	accounts []string
+}
+
+func (t *accountTask) Type() string { return "account" }
+
+func (t *accountTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	t.lock.Lock()
+	t.accounts = append(t.accounts, p.Props["account"])
+	t.lock.Unlock()
+	p.Status = SUCCESS
+}
+
+type feedFlow struct {
+	childFlow
+	task *accountTask
+}
+
+func (c *feedFlow) FlowFunc(threadId int) *Workflow {
+	w := MakeWorkflow()
+	a := w.MakeTaskNode("account", c.task)
+	w.SetStart(a)
+	w.SetEnd(a)
+	return w
+}
+
+func Test_FeederLauncher(t *testing.T) {
+	ff := &feedFlow{childFlow: childFlow{dir: t.TempDir()}, task: &accountTask{}}
+	ff.Init("feed")
+	l := MakeFlowLauncher(ff, 3, nil, nil)
+	l.SetFeeder(FeederFunc(func(threadId int) (Props, error) {
+		return Props{"account": "user-" + strconv.Itoa(threadId)}, nil
+	}))
+
+	run := func() *Params {
+		ec := make(chan *Params, 1)
+		go l.Start(time.Millisecond, ec)
+		select {
+		case end := <-ec:
+			return end
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not end")
+		}
+		return nil
+	}
+
+	if end := run(); end.Status != SUCCESS {
+		t.Fatal("run failed", end.Status)
+	}
+
+	sort.Strings(ff.task.accounts)
+	if len(ff.task.accounts) != 3 || ff.task.accounts[0] != "user-0" || ff.task.accounts[2] != "user-2" {
+		t.Error("threads not fed their own props", ff.task.accounts)
+	}
+
+	// a feeder that fails stops the run before any thread starts
+	ff.task.accounts = nil
+	l.SetFeeder(FeederFunc(func(threadId int) (Props, error) {
+		return nil, errors.New("no accounts left")
+	}))
+
+	if end := run(); end.Status != FAIL {
+		t.Error("expected the run to fail", end.Status)
+	}
+	if len(ff.task.accounts) != 0 || l.Error == "" {
+		t.Error("threads ran after the feeder failed", ff.task.accounts, l.Error)
+	}
+}
@@ -1,9 +1,12 @@
 package flow
 
 import (
+	"errors"
 	"fmt"
 	"github.com/golang/glog"
+	"io"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -49,23 +52,30 @@ func (b *BaseLaunchable) DefaultProps() *Props {
 // a load of structures to service a multiple workflow threads- which is passed in via the GetFlowFunc which constructs the workflow
 // these can be used to fire off parallel test workflows for example - as a load test
 // flowlaunchers are persistant in the list of Flows held in the project
-// flowlauncher creates N Flows so that each workflow runs in its own thread
+// each start of a flowlauncher is a Run that creates N Flows so that each workflow runs in its own thread - the
+// same launcher can have many runs going at once, each with its own workspace and results
 type FlowLauncher struct {
 	Name          string
 	Id            string
 	Order         int
 	flowFunc      GetFlowFunc
 	Threads       int
-	Flows         []*Workflow // each thread creates a full workflow in memory - so the implementor of tasks does not have to wory about thread conflicts
+	MaxRuns       int // the most runs of this flow the run queue starts at once - zero is no limit
 	Props         *Props
-	CStat         chan *Params
-	iEnd          chan *Params // internal end chanel for auto stepper
-	endParams     *Params
-	LastRunResult *FlowLaunchResult // a set of response stats by task id in our workflow for the last run
-	Error         string
+	LastRunResult *FlowLaunchResult // a set of response stats by task id in our workflow for the latest run to start
+	Error         string            // the error of the latest run to finish
 	initial       *FlowLauncher
 	trigger       *FlowLauncher
-	// TODO - historical stats / logs
+	isTrigger     bool   // this launcher runs a trigger flow
+	matrix        Matrix // if set there is a thread for each cell of the matrix
+	cells         []MatrixCell
+	feeder        Feeder          // if set gives each thread its own extra props
+	load          *LoadProfile    // if set controls when the threads start and how long they run
+	history       *RunList        // where each run is kept
+	runsLock      sync.Mutex      // guards the active runs, their workspaces and the latest results
+	active        map[string]*Run // the runs in progress by id
+	workspaces    map[string]bool // the workspaces the active runs are using
+	seq           int             // numbers the runs when there is no history
 }
 
 // make a flow launcher and specify any other initital flow to run before this one
@@ -84,49 +94,196 @@ func MakeFlowLauncher(launchable LaunchableFlow, threads int, initial *FlowLaunc
 	}
 }
 
-// the launchers trigger is the end tasknodes trigger
-func (fl *FlowLauncher) Trigger() chan *Params {
-	return fl.iEnd
+// run a thread for every combination of the matrix values instead of identical threads - each
+// thread has the values of its cell added to its props and the results are grouped by cell
+func (fl *FlowLauncher) SetMatrix(m Matrix) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	fl.matrix = m
+	fl.cells = m.Cells()
+	fl.Threads = len(fl.cells)
+	return nil
 }
 
-// can call own step - perhaps via ui
-func (fl *FlowLauncher) Step(v int) {
-	// cant step if there was a problem and we didnt make all threads
-	if len(fl.Flows) < fl.Threads {
-		glog.Error("not enough threads", fl.Id, len(fl.Flows), "<", fl.Threads)
-		return
+func (fl *FlowLauncher) Matrix() Matrix {
+	return fl.matrix
+}
+
+// the cells of the matrix indexed by thread id - empty if there is no matrix
+func (fl *FlowLauncher) Cells() []MatrixCell {
+	return fl.cells
+}
+
+// give each thread of a run its own props from the feeder - they are added after any matrix props
+func (fl *FlowLauncher) SetFeeder(f Feeder) {
+	fl.feeder = f
+}
+
+// start the threads with a ramp up or at a rate and keep them running for a time - see LoadProfile
+func (fl *FlowLauncher) SetLoad(lp LoadProfile) error {
+	if err := lp.Validate(); err != nil {
+		return err
+	}
+	fl.load = &lp
+	return nil
+}
+
+func (fl *FlowLauncher) Load() *LoadProfile {
+	return fl.load
+}
+
+// keep every run in the run list - trigger flows are not kept
+func (fl *FlowLauncher) SetHistory(rl *RunList) {
+	fl.history = rl
+}
+
+func (fl *FlowLauncher) History() *RunList {
+	return fl.history
+}
+
+// make a run with its id and workspace and add it to the active runs
+func (fl *FlowLauncher) newRun(props Props, d *Debugger, user string) *Run {
+	r := &Run{
+		FlowId:   fl.Id,
+		User:     user,
+		Start:    time.Now(),
+		Running:  true,
+		Props:    fl.initialProps(props),
+		launcher: fl,
+		debug:    d,
+	}
+
+	fl.runsLock.Lock()
+	defer fl.runsLock.Unlock()
+
+	if fl.active == nil {
+		fl.active = map[string]*Run{}
+		fl.workspaces = map[string]bool{}
+	}
+
+	// a run started while another is going gets a workspace of its own
+	base := r.Props[KEY_WORKSPACE]
+	ws := base
+	for n := 2; fl.workspaces[ws]; n++ {
+		ws = fmt.Sprintf("%s_%d", base, n)
+	}
+	fl.workspaces[ws] = true
+	r.Props[KEY_WORKSPACE] = ws
+
+	if fl.history != nil && !fl.isTrigger {
+		if err := fl.history.AddRun(r); err != nil {
+			glog.Error("could not save run ", r.Id, " ", err)
+		}
+	} else {
+		fl.seq++
+		r.Number = fl.seq
+		r.Id = fmt.Sprintf("%s-%d", fl.Id, r.Number)
+	}
+
+	fl.active[r.Id] = r
+
+	glog.Info("new run ", r.Id, " in ", ws)
+	r.publish(EVENT_RUN_START)
+	return r
+}
+
+// the run is over - take it out of the active runs and keep how it ended
+func (fl *FlowLauncher) endRun(r *Run, p *Params) {
+	r.lock.Lock()
+	r.End = time.Now()
+	r.Status = p.Status
+	r.EndParams = p
+	r.Running = false
+	if r.Result != nil {
+		r.Cancelled = r.Cancelled || r.Result.cancelled()
+	}
+	r.lock.Unlock()
+
+	fl.runsLock.Lock()
+	delete(fl.active, r.Id)
+	delete(fl.workspaces, r.Props[KEY_WORKSPACE])
+	fl.Error = r.Error
+	fl.runsLock.Unlock()
+
+	glog.Info("end run ", r.Id, " with status ", StatusName(p.Status))
+	r.publish(EVENT_RUN_END)
+
+	if fl.history != nil && !fl.isTrigger {
+		if err := fl.history.Save(r); err != nil {
+			glog.Error("could not save run ", r.Id, " ", err)
+		}
+	}
+}
+
+// the run with this id - whether it is in progress or in the history, nil if there is none
+func (fl *FlowLauncher) Run(id string) *Run {
+	fl.runsLock.Lock()
+	r, ok := fl.active[id]
+	fl.runsLock.Unlock()
+	if ok {
+		return r
+	}
+	if fl.history != nil {
+		return fl.history.Get(id)
 	}
-	glog.Info("<<<<<<<<<<<<<<<<<<<<<<<<<<< step")
-	for i := 0; i < fl.Threads; i++ {
-		fl.Flows[i].Stepper <- v
+	return nil
+}
+
+// the runs in progress - oldest first
+func (fl *FlowLauncher) ActiveRuns() []*Run {
+	fl.runsLock.Lock()
+	defer fl.runsLock.Unlock()
+	runs := make([]*Run, 0, len(fl.active))
+	for _, r := range fl.active {
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Number < runs[j].Number
+	})
+	return runs
+}
+
+// can call own step - perhaps via ui - steps every active run
+func (fl *FlowLauncher) Step(v int) {
+	for _, r := range fl.ActiveRuns() {
+		r.step(v)
 	}
 }
 
-// this allows us to set the pace at which each step can move on
-func (fl *FlowLauncher) AutoStep(delay time.Duration, endChan chan *Params) {
-	loop := true
+// this allows us to set the pace at which each step of the run can move on
+func (fl *FlowLauncher) AutoStep(r *Run, delay time.Duration, endChan chan *Params) {
+	stop := make(chan struct{})
 
-	// swallow statuses
+	// push the statuses to anyone listening
 	go func() {
-		for stat := range fl.CStat {
+		for stat := range r.cstat {
 			glog.Info("<<< status event ", stat)
+			r.publishStatus(stat)
 		}
 		glog.Info("loop stoppped")
 	}()
 
-	go func() {
-		for loop {
-			if loop {
+	// the debugger releases the nodes in debug mode
+	if r.debug == nil {
+		go func() {
+			for {
 				glog.V(2).Infoln("firing step evenct >>>")
-				go fl.Step(1)
+				go r.step(1)
+				select {
+				case <-stop:
+					glog.Info("stepper loop stoppped")
+					return
+				case <-time.After(delay):
+				}
 			}
-			time.Sleep(delay)
-		}
-		glog.Info("stepper loop stoppped")
-	}()
+		}()
+	}
+
+	res := <-r.iEnd
+	close(stop)
 
-	res := <-fl.iEnd
-	loop = false
+	fl.endRun(r, res)
 
 	glog.Info("endChan <<<")
 	if endChan != nil {
@@ -135,30 +292,83 @@ func (fl *FlowLauncher) AutoStep(delay time.Duration, endChan chan *Params) {
 }
 
 func (fl *FlowLauncher) TrashLastResults() {
+	fl.runsLock.Lock()
+	defer fl.runsLock.Unlock()
 	fl.LastRunResult = nil
 }
 
-// make the object to capture the result
+// the results of the latest run to start
+func (fl *FlowLauncher) lastResult() *FlowLaunchResult {
+	fl.runsLock.Lock()
+	defer fl.runsLock.Unlock()
+	return fl.LastRunResult
+}
+
+// make the object to capture the result of the run
 // and set the result stream objects on the nodes in this flow
-func (fl *FlowLauncher) MakeLaunchResults(tf *Workflow) {
+func (fl *FlowLauncher) MakeLaunchResults(r *Run, tf *Workflow) {
 
 	// new stats for this run
-	fl.LastRunResult = NewFlowLaunchResult(fl.Threads)
+	res := NewFlowLaunchResult(fl.Threads)
+	res.FlowId = fl.Id
+	res.addCells(fl.cells)
 
 	// TaskNodes
 	for _, n := range tf.TaskNodes {
 		glog.Info("add name node to run result: ", n.Name())
-		ls, err := fl.LastRunResult.AddTask(MakeID(n.Name()))
+		_, err := res.AddTask(MakeID(n.Name()))
 		if err != nil {
 			glog.Error("dodgy nodes ", err)
-		} else {
-			n.SetStream(ls.CommandStream)
+		}
+
+		// nodes that run nodes of their own
+		if sn, ok := n.(subNodeRunner); ok {
+			for _, id := range sn.subNodeIds() {
+				_, err := res.AddTask(id)
+				if err != nil {
+					glog.Error("dodgy nodes ", err)
+				}
+			}
+		}
+	}
+
+	r.lock.Lock()
+	r.Result = res
+	r.lock.Unlock()
+
+	fl.runsLock.Lock()
+	fl.LastRunResult = res
+	fl.runsLock.Unlock()
+
+	fl.setStreams(r, tf)
+}
+
+// point the nodes of the flow at the result streams - so their output is captured
+func (fl *FlowLauncher) setStreams(r *Run, tf *Workflow) {
+	for _, n := range tf.TaskNodes {
+		if cs := r.Result.stream(MakeID(n.Name())); cs != nil {
+			n.SetStream(cs)
+		}
+
+		if sn, ok := n.(subNodeRunner); ok {
+			for _, id := range sn.subNodeIds() {
+				if cs := r.Result.stream(id); cs != nil {
+					sn.setSubStream(id, cs)
+				}
+			}
 		}
 	}
 }
 
-// clear out the workspace if required - backs up last workspace
-func (fl *FlowLauncher) TidyDeskPolicy(p Props) bool {
+// a node that runs its own nodes - e.g. a for each - whose results are reported with the flows
+type subNodeRunner interface {
+	subNodeIds() []string
+	setSubStream(id string, cs *io.PipeWriter)
+}
+
+// clear out the workspace of the run if required - backs up last workspace
+func (fl *FlowLauncher) TidyDeskPolicy(r *Run) bool {
+	p := r.Props
 	ws := p[KEY_WORKSPACE]
 	if p[KEY_TIDY_DESK] != "keep" {
 
@@ -167,8 +377,7 @@ func (fl *FlowLauncher) TidyDeskPolicy(p Props) bool {
 		if err != nil {
 			if !os.IsNotExist(err) {
 				glog.Error(err)
-				fl.Error = err.Error()
-				fl.LastRunResult.Error = fl.Error
+				r.setError(err.Error())
 				return false
 			}
 		}
@@ -176,8 +385,7 @@ func (fl *FlowLauncher) TidyDeskPolicy(p Props) bool {
 		if err != nil {
 			if !os.IsNotExist(err) {
 				glog.Error(err)
-				fl.Error = err.Error()
-				fl.LastRunResult.Error = fl.Error
+				r.setError(err.Error())
 				return false
 			}
 		}
@@ -192,8 +400,7 @@ func (fl *FlowLauncher) TidyDeskPolicy(p Props) bool {
 			return true
 		}
 		glog.Error(err)
-		fl.Error = err.Error()
-		fl.LastRunResult.Error = fl.Error
+		r.setError(err.Error())
 		return false
 	}
 
@@ -202,8 +409,7 @@ func (fl *FlowLauncher) TidyDeskPolicy(p Props) bool {
 	err = os.MkdirAll(ts, 0777)
 	if err != nil {
 		glog.Error(err)
-		fl.Error = err.Error()
-		fl.LastRunResult.Error = fl.Error
+		r.setError(err.Error())
 		return false
 	}
 
@@ -217,139 +423,208 @@ func (fl *FlowLauncher) MakeFlow(threadId int) *Workflow {
 	return w
 }
 
-func (fl *FlowLauncher) Prep(isTrigger bool) bool {
+func (fl *FlowLauncher) Prep(r *Run, isTrigger bool) bool {
 
 	// check we have a good workflow
 	flow := fl.MakeFlow(0)
 
-	// the final set of params set at the end of the flow - last finishing thread wins
-	fl.endParams = MakeParams()
-	fl.endParams.Props = *fl.Props // set up with initial props
-
-	// copy the flow name
-	fl.endParams.FlowName = flow.Name
-
-	glog.Info("workflow prep ", flow.Name)
+	// the final set of params set at the end of the flow - the first thread that did not succeed sets the status
+	r.endParams = MakeParams()
+	r.endParams.Props = r.threadProps(-1) // set up with initial props
 
 	// check some conditions...
 	if flow == nil {
-		glog.Error(flow.Name, " has no flow")
+		glog.Error(fl.Name, " has no flow")
+		r.setError("no flow")
+		r.endParams.Status = FAIL
+		close(r.cstat)
+		r.iEnd <- r.endParams
 		return false
 	}
 
-	if !isTrigger && flow.Start == nil {
-		glog.Error(flow.Name, " has no flow start")
-		return false
-	}
+	// copy the flow name
+	r.endParams.FlowName = flow.Name
+
+	glog.Info("workflow prep ", flow.Name)
+
+	// the results are made before any thread starts as they all report to it - thread 0 reuses this flow
+	// so its nodes keep the result streams
+	fl.MakeLaunchResults(r, flow)
 
-	if flow.End == nil {
-		glog.Error(flow.Name, " has no flow end")
+	// dont even start a flow that can not work - including one with no start or end
+	issues := flow.Validate(isTrigger)
+	for _, vi := range issues {
+		glog.Warning(vi)
+	}
+	if issues.HasErrors() {
+		r.setError(issues.Error())
+		r.endParams.Status = FAIL
+		close(r.cstat)
+		r.iEnd <- r.endParams
 		return false
 	}
 
-	// make fresh chanels on each exec as they were probably closed
-	fl.CStat = make(chan *Params)
-	fl.iEnd = make(chan *Params)
-
-	fl.Error = ""
+	// get the data for each thread before anything is touched
+	if fl.feeder != nil {
+		fed, err := fl.feeder.Feed(fl.Threads)
+		if err != nil {
+			glog.Error("feeder failed ", err)
+			r.setError("feeder: " + err.Error())
+			r.endParams.Status = FAIL
+			close(r.cstat)
+			r.iEnd <- r.endParams
+			return false
+		}
+		r.fed = fed
+	}
 
-	if fl.TidyDeskPolicy(*fl.Props) == false {
-		fl.endParams.Status = FAIL
-		fl.iEnd <- fl.endParams
+	if fl.TidyDeskPolicy(r) == false {
+		r.endParams.Status = FAIL
+		close(r.cstat)
+		r.iEnd <- r.endParams
 		return false
 	}
 
-	fl.Flows = make([]*Workflow, fl.Threads, fl.Threads)
+	r.lock.Lock()
+	r.flows = make([]*Workflow, fl.Threads, fl.Threads)
+	r.flows[0] = flow
+	r.lock.Unlock()
 
 	return true
 }
 
 // p are initial environment properties
 // run a workflow with this number of threads and gather the success/fail response statistics
-func (fl *FlowLauncher) Exec() {
-	if !fl.Prep(false) {
+func (fl *FlowLauncher) Exec(r *Run) {
+	if !fl.Prep(r, false) {
 		return
 	}
 
-	glog.Info("workflow launcher ", fl.Name, " with ", fl.Threads, " threads")
-
-	var waitGroup sync.WaitGroup
-
-	// fire off some threads - (in parallel)
-	for i := 0; i < fl.Threads; i++ {
-		waitGroup.Add(1)
-
-		go fl.execOneFlow(i, &waitGroup, false)
-	}
+	glog.Info("workflow launcher ", fl.Name, " run ", r.Id, " with ", fl.Threads, " threads")
 
 	// now lets wait for all the threads to finish
-	// TODO a timeout as well in case of bad flows...
 	go func() {
-		waitGroup.Wait()
+		if fl.load != nil {
+			fl.execLoad(r)
+		} else {
+			var waitGroup sync.WaitGroup
+
+			// fire off some threads - (in parallel)
+			for i := 0; i < fl.Threads; i++ {
+				waitGroup.Add(1)
+
+				go fl.execOneFlow(r, i, &waitGroup, false)
+			}
+			waitGroup.Wait()
+		}
 		// once we get past the waitgroup then all threads have completed
-		glog.Info("completed launcher ", fl.Name, " with ", fl.Threads, " threads")
-		// mark status
-		fl.LastRunResult.Completed = true
+		glog.Info("completed launcher ", fl.Name, " run ", r.Id, " with ", fl.Threads, " threads")
+		// mark status - a stopped run is not a normal failure
+		r.lock.Lock()
+		r.Result.complete()
+		if r.Result.cancelled() {
+			r.endParams.Status = CANCELLED
+		}
+		r.lock.Unlock()
 
 		// close the status channel
-		close(fl.CStat)
+		close(r.cstat)
 
 		// and trigger the end event
-		fl.iEnd <- fl.endParams
+		r.iEnd <- r.endParams
 	}()
 }
 
 // run the trigger flow
-func (fl *FlowLauncher) ExecTrigger() {
-	if !fl.Prep(true) {
+func (fl *FlowLauncher) ExecTrigger(r *Run) {
+	if !fl.Prep(r, true) {
 		return
 	}
 
 	glog.Info("workflow trigger ", fl.Name)
 
-	fl.execOneFlow(0, nil, true)
+	par := fl.execOneFlow(r, 0, nil, true)
+
+	// pass on anything the trigger added to the props - so the triggered flow can use it
+	for k, v := range fl.addedProps(par.Props) {
+		r.endParams.Props[k] = v
+	}
 
 	glog.Info("completed trigger ", fl.Name)
 
-	// make sure we mark the single flow thread as stopped so no other triggers can do much
-	fl.Flows[0].Stop = true
+	// stop the single flow thread so any other triggers still waiting give up
+	r.activeFlows()[0].Cancel()
 
 	// mark status
-	fl.LastRunResult.Completed = true
+	r.Result.complete()
 
 	// close the status channel
-	close(fl.CStat)
+	close(r.cstat)
 
 	// and trigger the end event
-	fl.iEnd <- fl.endParams
+	r.iEnd <- r.endParams
 }
 
-// launch one flow thread if isTrigger is set then the Flow is launched in the specific trigger style
-func (fl *FlowLauncher) execOneFlow(i int, waitGroup *sync.WaitGroup, isTrigger bool) {
+// the launchers props with any props for a run added - a fresh copy every time
+func (fl *FlowLauncher) initialProps(runProps Props) Props {
+	props := Props{}
+	for k, v := range *fl.Props {
+		props[k] = v
+	}
+	for k, v := range runProps {
+		props[k] = v
+	}
+	return props
+}
 
-	// create a new workflow
-	flow := fl.MakeFlow(i)
+// the props in p that are not part of the launchers own props
+func (fl *FlowLauncher) addedProps(p Props) Props {
+	added := Props{}
+	for k, v := range p {
+		if _, ok := (*fl.Props)[k]; !ok {
+			added[k] = v
+		}
+	}
+	return added
+}
 
-	// save it for later
-	fl.Flows[i] = flow
+// launch one flow thread of the run if isTrigger is set then the Flow is launched in the specific trigger style
+// returns the params the flow ended with
+func (fl *FlowLauncher) execOneFlow(r *Run, i int, waitGroup *sync.WaitGroup, isTrigger bool) *Params {
 
-	glog.Info("workflow launch ", flow.Name, " with threadid ", i)
+	// create a new workflow - unless prep already made it
+	r.lock.Lock()
+	flow := r.flows[i]
+	if flow == nil {
+		flow = fl.MakeFlow(i)
+		// save it for later
+		r.flows[i] = flow
+	}
+	flow.debugger = r.debug
+	flow.runId = r.Id
+	cancelled := r.Cancelled
+	r.lock.Unlock()
 
-	// TOOD - here you would inject any function to vary the params per thread
-	// copy the params and add initial props
+	// a thread 0 that runs again still streams its output
+	if i == 0 {
+		fl.setStreams(r, flow)
+	}
+
+	// stopped before the thread got going
+	if cancelled {
+		flow.Cancel()
+	}
+
+	glog.Info("workflow launch ", flow.Name, " run ", r.Id, " with threadid ", i)
+
+	// copy the params and add the run props - and the values of our matrix cell and from the feeder
 	params := MakeParams()
-	params.Props = *fl.Props
+	params.Props = r.threadProps(i)
 	params.FlowName = flow.Name
 	params.ThreadId = i
 
 	glog.Info("firing task with params ", params)
 
-	// for the first thread make the results object including
-	// setting the result stream objects on the nodes in this flow
-	if i == 0 {
-		fl.MakeLaunchResults(flow)
-	}
-
 	// and fire of the workflow
 	if isTrigger {
 		go flow.StartTriggers(params)
@@ -358,13 +633,15 @@ func (fl *FlowLauncher) execOneFlow(i int, waitGroup *sync.WaitGroup, isTrigger
 	}
 
 	// loop round forwarding status updates
+	forwarded := make(chan struct{})
 	go func() {
+		defer close(forwarded)
 		glog.Info("waiting on chanel flow.C ")
 		for stat := range flow.C {
 			glog.Info("got status ", stat)
 
-			fl.LastRunResult.AddStatusOrResult(stat)
-			fl.CStat <- stat // tiger any stats change chanel (e.g. for push messages like websockets)
+			r.Result.AddStatusOrResult(stat)
+			r.cstat <- stat // tiger any stats change chanel (e.g. for push messages like websockets)
 		}
 		glog.Info("launcher status loop stoppped", i, " ", flow.End.Name())
 	}()
@@ -373,74 +650,166 @@ func (fl *FlowLauncher) execOneFlow(i int, waitGroup *sync.WaitGroup, isTrigger
 	par := <-flow.End.DoneChan()
 
 	glog.Info("got flow end ", par.ThreadId, " ", flow.End.Name())
-	// collect all end event triggers - last par wins
-	fl.endParams.Status = fl.endParams.Status + par.Status
+	// collect all end event triggers - the run takes the status of the first thread that did not succeed
+	r.lock.Lock()
+	if r.endParams.Status == SUCCESS {
+		r.endParams.Status = par.Status
+	}
+	r.Result.endCell(par)
+	r.lock.Unlock()
 
-	// update metrics
-	now := time.Now()
-	fl.LastRunResult.Duration = now.Sub(fl.LastRunResult.Start)
+	// the thread is over - stop anything still running in it and release any steps waiting on it
+	flow.Cancel()
 
-	// close the flow status chanel
+	// close the flow status chanel - and let the last status through before anyone closes CStat
 	close(flow.C)
+	<-forwarded
 
 	if waitGroup != nil {
 		waitGroup.Done()
 	}
+
+	return par
 }
 
 // main entry point - this may launch a dependant initial workflow - and block on that
 func (fl *FlowLauncher) Start(delay time.Duration, endChan chan *Params) {
-	// wipe previous results
-	fl.TrashLastResults()
+	fl.StartWith(delay, nil, endChan)
+}
+
+// start the flow with some extra props added to the launchers props for this run
+func (fl *FlowLauncher) StartWith(delay time.Duration, props Props, endChan chan *Params) {
+	fl.StartRun(delay, props, endChan)
+}
+
+// start a run of the flow and return it straight away - its id can be used to follow it or stop it
+// and endChan gets the params it ended with
+func (fl *FlowLauncher) StartRun(delay time.Duration, props Props, endChan chan *Params) *Run {
+	return fl.StartRunAs("", delay, props, endChan)
+}
+
+// start a run on behalf of the user - who is recorded on the run
+func (fl *FlowLauncher) StartRunAs(user string, delay time.Duration, props Props, endChan chan *Params) *Run {
+	r := fl.newRun(props, nil, user)
+	go fl.start(r, delay, endChan)
+	return r
+}
+
+// start the flow in debug mode - its nodes wait at the breakpoints (or all of them if it is started
+// paused) until they are released through the debugger of the returned run, any initial flow runs
+// as normal
+func (fl *FlowLauncher) StartDebug(user string, paused bool, breakpoints []string, props Props, endChan chan *Params) *Run {
+	d := NewDebugger(paused, breakpoints)
+	r := fl.newRun(props, d, user)
+	go fl.start(r, time.Second, endChan)
+	return r
+}
+
+// the debugger of the run in progress with this id - nil if there is no such run or it is a normal
+// run, runs of the same flow can be debugged at the same time so they are always picked by id
+func (fl *FlowLauncher) Debugger(runId string) *Debugger {
+	fl.runsLock.Lock()
+	defer fl.runsLock.Unlock()
+	r, ok := fl.active[runId]
+	if !ok {
+		return nil
+	}
+	return r.debug
+}
+
+func (fl *FlowLauncher) start(r *Run, delay time.Duration, endChan chan *Params) {
+	// the run can not go on - let whoever started us know we never ran
+	never := func(msg string, status int) {
+		p := MakeParams()
+		p.FlowName = fl.Name
+		p.Status = status
+		p.Response = msg
+		r.setError(msg)
+		fl.endRun(r, p)
+		if endChan != nil {
+			endChan <- p
+		}
+	}
 
 	if fl.initial != nil {
 
 		ec := make(chan *Params)
 
-		go fl.initial.Start(delay, ec)
+		ir := fl.initial.StartRun(delay, nil, ec)
+		r.lock.Lock()
+		r.initial = ir
+		r.lock.Unlock()
 
 		// block waiting for initial to complete
 		res := <-ec
 
-		fmt.Println("initial flow end result", res)
+		glog.Info("initial flow end result ", res)
 
-		if res.Status == 0 {
-			fmt.Println("FLOW SUCCEEDED")
+		if res.Status == SUCCESS {
+			glog.Info("initial flow ", fl.initial.Name, " succeeded")
 		} else {
-			fmt.Println("FLOW FAILED")
+			glog.Warning("initial flow ", fl.initial.Name, " failed")
+			never("initial flow "+fl.initial.Name+" failed", FAIL)
 			return
 		}
 	}
 
-	go fl.Exec()
+	r.lock.Lock()
+	cancelled := r.Cancelled
+	r.lock.Unlock()
+	if cancelled {
+		never("stopped before it started", CANCELLED)
+		return
+	}
 
-	go fl.AutoStep(delay, endChan)
+	r.makeChannels()
+
+	go fl.Exec(r)
+
+	go fl.AutoStep(r, delay, endChan)
 }
 
 func (fl *FlowLauncher) StartTrigger(delay time.Duration, endChan chan *Params) {
-	fl.TrashLastResults()
-	go fl.ExecTrigger()
+	r := fl.newRun(nil, nil, "")
+	r.makeChannels()
+	go fl.ExecTrigger(r)
 
-	go fl.AutoStep(delay, endChan)
+	go fl.AutoStep(r, delay, endChan)
 }
 
+// stop every run in progress
 func (fl *FlowLauncher) ExterminateExterminate() {
-	if len(fl.Flows) == 0 {
+	runs := fl.ActiveRuns()
+	if len(runs) == 0 {
 		glog.Warning("stop called on none started launcher")
 		return
 	}
 
-	// set stop on all active threads
-	for i := 0; i < fl.Threads; i++ {
-		f := fl.Flows[i]
-		if f != nil {
-			f.Stop = true
-		}
+	// cancel all active runs - which kills any running tasks
+	for _, r := range runs {
+		r.stop()
+	}
+}
+
+// stop the run in progress with this id
+func (fl *FlowLauncher) StopRun(id string) error {
+	fl.runsLock.Lock()
+	r, ok := fl.active[id]
+	fl.runsLock.Unlock()
+	if !ok {
+		return errors.New("no run in progress: " + id)
 	}
+	r.stop()
+	return nil
+}
+
+// check the structure of this launchers flow
+func (fl *FlowLauncher) Validate() ValidationIssues {
+	return fl.MakeFlow(0).Validate(fl.isTrigger)
 }
 
 // return the flow structure - for interfaces
-func (fl FlowLauncher) GetStructure() FlowStruct {
+func (fl *FlowLauncher) GetStructure() FlowStruct {
 	// make a flow just so we can render it in json
 	f := fl.MakeFlow(0)
 	return f.GetStructure(fl.Order)
@@ -2,9 +2,12 @@ package flow
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
 	"github.com/golang/glog"
 	"io"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,16 +15,93 @@ import (
 type FlowLauncherStats struct {
 	Complete        int
 	Failed          int
+	TimedOut        int
+	Cancelled       int
+	Waiting         int               // threads waiting for approval at a gate
+	PercentComplete int               // of the threads - for the latest iteration if the task is in a loop
+	Iteration       int               // the latest loop iteration any thread has reached - zero if not in a loop
+	Iterations      []*IterationStats // the stats for each iteration of a task in a loop
+	Latency         *LatencyStats     // how long the executions of the task took - across all threads
+	CommandOutput   []string          // lines of shell output
+	CommandStream   *io.PipeWriter    `json:"-"` // the writer that is used to pipe stdout and stdErr - and captured in CommandOutput
+	reader          *io.PipeReader    // read from this to fill the CommandOutput
+	attemptFrom     int               // where in the CommandOutput the current attempt started
+	more            chan struct{}     // closed when more lines are added to the CommandOutput
+	waiting         map[int]bool      // the threads that are waiting by thread id
+}
+
+// the stats for one iteration of a task inside a loop
+type IterationStats struct {
+	Iteration       int
+	Complete        int
+	Failed          int
+	TimedOut        int
 	PercentComplete int
-	CommandOutput   []string       // lines of shell output
-	CommandStream   *io.PipeWriter // the writer that is used to pipe stdout and stdErr - and captured in CommandOutput
-	reader          *io.PipeReader // read from this to fill the CommandOutput
+}
+
+// the stats for iteration i - added if needed
+func (s *FlowLauncherStats) iteration(i int) *IterationStats {
+	for _, is := range s.Iterations {
+		if is.Iteration == i {
+			return is
+		}
+	}
+	is := &IterationStats{Iteration: i}
+	s.Iterations = append(s.Iterations, is)
+	return is
+}
+
+func percent(n, of int) int {
+	if of <= 0 || n >= of {
+		return 100
+	}
+	return (n * 100) / of
+}
+
+// the outcome of a single attempt of a task with a retry policy
+type AttemptResult struct {
+	Attempt  int
+	ThreadId int
+	Params   *Params
+	Output   []string // the lines of CommandOutput produced by this attempt (only for the streamed thread)
 }
 
 type StepResult struct {
 	Stats      *FlowLauncherStats // a set of response stats by task id in our workflow for the last run
 	StartParam *Params
 	EndParam   *Params
+	Attempts   []*AttemptResult // each attempt when the task was retried
+	SubRuns    []*SubFlowRun    // the runs of another flow started by a sub flow node
+}
+
+// record the params and output of one attempt
+func (r *StepResult) addAttempt(p *Params) {
+	ar := &AttemptResult{
+		Attempt:  p.Attempt,
+		ThreadId: p.ThreadId,
+		Params:   p,
+	}
+
+	// only one thread streams its output
+	if r.Stats.CommandStream != nil && p.ThreadId == 0 {
+		out := r.Stats.CommandOutput
+		if r.Stats.attemptFrom <= len(out) {
+			ar.Output = append([]string{}, out[r.Stats.attemptFrom:]...)
+		}
+		r.Stats.attemptFrom = len(out)
+	}
+
+	r.Attempts = append(r.Attempts, ar)
+}
+
+// the result of the thread that ran one cell of a matrix
+type CellResult struct {
+	MatrixCell
+	Completed bool
+	Status    int
+	Response  string
+	Failed    []string // the ids of the tasks that did not succeed
+	Outputs   Props    // the task outputs of this cell as <task-id>.<key>
 }
 
 type FlowLaunchResult struct {
@@ -30,8 +110,15 @@ type FlowLaunchResult struct {
 	Start        time.Time
 	Duration     time.Duration
 	Completed    bool
+	Cancelled    bool                   // the run was stopped
 	Results      map[string]*StepResult // a set of response stats by task id in our workflow for the last run
 	TotalThreads int
+	Outputs      Props               // all the task outputs (from the first thread) as <task-id>.<key>
+	Cells        []*CellResult       // by thread id if the launcher has a matrix
+	Runs         int                 // how many times a thread ran the flow - more than the threads if they kept running
+	Concurrency  []ConcurrencySample // the number of flows running through a load run
+	Peak         int                 // the most flows that ran at once in a load run
+	lock         sync.Mutex          // each thread adds its statuses from its own routine
 }
 
 func NewFlowLaunchResult(threads int) *FlowLaunchResult {
@@ -40,11 +127,165 @@ func NewFlowLaunchResult(threads int) *FlowLaunchResult {
 		Start:        time.Now(),
 		Completed:    false,
 		Results:      make(map[string]*StepResult),
+		Outputs:      Props{},
 		TotalThreads: threads, // how many threads should be executed
 	}
 	return flr
 }
 
+// the json of the results so far - taken under the lock as the threads are still adding to them
+func (f *FlowLaunchResult) MarshalJSON() ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return json.Marshal((*flowLaunchResult)(f))
+}
+
+// the fields of a launch result without its MarshalJSON
+type flowLaunchResult FlowLaunchResult
+
+func (f *FlowLaunchResult) complete() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.Completed = true
+}
+
+func (f *FlowLaunchResult) cancel() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.Cancelled = true
+}
+
+func (f *FlowLaunchResult) cancelled() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.Cancelled
+}
+
+func (f *FlowLaunchResult) setError(msg string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.Error = msg
+}
+
+func (c *CellResult) failed(taskId string) bool {
+	for _, id := range c.Failed {
+		if id == taskId {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FlowLaunchResult) addCells(cells []MatrixCell) {
+	for _, c := range cells {
+		f.Cells = append(f.Cells, &CellResult{
+			MatrixCell: c,
+			Outputs:    Props{},
+		})
+	}
+}
+
+// the cell the thread ran - nil if there is no matrix
+func (f *FlowLaunchResult) cell(threadId int) *CellResult {
+	if threadId < 0 || threadId >= len(f.Cells) {
+		return nil
+	}
+	return f.Cells[threadId]
+}
+
+// the writer that captures the output of the task - nil if it is not in the results
+func (f *FlowLaunchResult) stream(taskId string) *io.PipeWriter {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	res, ok := f.Results[taskId]
+	if !ok {
+		return nil
+	}
+	return res.Stats.CommandStream
+}
+
+// the output of one task of a run
+type TaskOutput struct {
+	TaskId   string
+	Lines    []string         // the shell output of the streamed thread
+	Attempts []*AttemptResult // each attempt when the task was retried
+	Outputs  Props            // the outputs of the task as <task-id>.<key>
+}
+
+// a copy of the output of the task so far - false if it is not in the results
+func (f *FlowLaunchResult) TaskOutput(taskId string) (*TaskOutput, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	res, ok := f.Results[taskId]
+	if !ok {
+		return nil, false
+	}
+	to := &TaskOutput{
+		TaskId:   taskId,
+		Lines:    append([]string{}, res.Stats.CommandOutput...),
+		Attempts: append([]*AttemptResult{}, res.Attempts...),
+		Outputs:  Props{},
+	}
+	prefix := OutputKey(taskId, "")
+	for k, v := range f.Outputs {
+		if strings.HasPrefix(k, prefix) {
+			to.Outputs[k] = v
+		}
+	}
+	return to, true
+}
+
+// the lines of the output of the task from line from on - and a chanel that is closed when more lines
+// are added, false if the task is not in the results
+func (f *FlowLaunchResult) TailOutput(taskId string, from int) ([]string, <-chan struct{}, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	res, ok := f.Results[taskId]
+	if !ok {
+		return nil, nil, false
+	}
+	out := res.Stats.CommandOutput
+	if from < 0 {
+		from = 0
+	}
+	if from > len(out) {
+		from = len(out)
+	}
+	if res.Stats.more == nil {
+		res.Stats.more = make(chan struct{})
+	}
+	return append([]string{}, out[from:]...), res.Stats.more, true
+}
+
+func (f *FlowLaunchResult) sample(at time.Duration, running int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.Concurrency = append(f.Concurrency, ConcurrencySample{At: at, Running: running})
+}
+
+func (f *FlowLaunchResult) peak(running int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if running > f.Peak {
+		f.Peak = running
+	}
+}
+
+// record how a thread ended - and the cell it ran if there is a matrix
+func (f *FlowLaunchResult) endCell(p *Params) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.Duration = time.Since(f.Start)
+	f.Runs++
+	c := f.cell(p.ThreadId)
+	if c == nil {
+		return
+	}
+	c.Completed = true
+	c.Status = p.Status
+	c.Response = p.Response
+}
+
 func (f *FlowLaunchResult) AddTask(taskId string) (*FlowLauncherStats, error) {
 	glog.Infof("add result task %s \n", taskId)
 
@@ -58,6 +299,7 @@ func (f *FlowLaunchResult) AddTask(taskId string) (*FlowLauncherStats, error) {
 			CommandStream: wp,
 			reader:        rp,
 			CommandOutput: []string{},
+			Latency:       NewLatencyStats(),
 		}
 
 		// start the threads to monitor the reader
@@ -66,7 +308,13 @@ func (f *FlowLaunchResult) AddTask(taskId string) (*FlowLauncherStats, error) {
 			for scanner.Scan() {
 				t := scanner.Text()
 				glog.Infof("%s%s \n", ">> console: ", t)
+				f.lock.Lock()
 				s.CommandOutput = append(s.CommandOutput, t)
+				if s.more != nil {
+					close(s.more)
+					s.more = nil
+				}
+				f.lock.Unlock()
 			}
 			if err := scanner.Err(); err != nil {
 				glog.Error("There was an error with the scanner in attached container ", err)
@@ -83,6 +331,9 @@ func (f *FlowLaunchResult) AddTask(taskId string) (*FlowLauncherStats, error) {
 
 func (f *FlowLaunchResult) AddStatusOrResult(statusParams *Params) {
 
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
 	id := statusParams.TaskId
 	complete := statusParams.Complete
 	status := statusParams.Status
@@ -92,25 +343,102 @@ func (f *FlowLaunchResult) AddStatusOrResult(statusParams *Params) {
 		panic("a task id was changed or added after initialisation of the flow")
 	}
 
+	// an attempt that is going to be retried does not complete the task
+	if statusParams.Retrying {
+		res.addAttempt(statusParams)
+		return
+	}
+
 	stat := res.Stats
+	if statusParams.subRun != nil && complete {
+		res.SubRuns = append(res.SubRuns, statusParams.subRun)
+	}
+
+	// a new loop iteration starts the progress again
+	if statusParams.Iteration > stat.Iteration {
+		stat.Iteration = statusParams.Iteration
+		stat.PercentComplete = 0
+	}
+
 	// mark it at least one percent complete so we can see that it is in progress
-	stat.PercentComplete = 1
+	if stat.PercentComplete == 0 {
+		stat.PercentComplete = 1
+	}
+
+	// the last attempt of a retried task
+	if complete && statusParams.Attempt > 0 {
+		res.addAttempt(statusParams)
+	}
+
+	// parked at a gate
+	if status == WAITING || stat.waiting[statusParams.ThreadId] {
+		if stat.waiting == nil {
+			stat.waiting = map[int]bool{}
+		}
+		if status == WAITING && !complete {
+			stat.waiting[statusParams.ThreadId] = true
+		} else {
+			delete(stat.waiting, statusParams.ThreadId)
+		}
+		stat.Waiting = len(stat.waiting)
+	}
 
 	if complete {
 		stat.Complete = stat.Complete + 1
 
-		if status == 1 {
+		if status == FAIL {
 			stat.Failed = stat.Failed + 1
 		}
 
-		stat.PercentComplete = (stat.Complete * 100) / f.TotalThreads
+		if status == TIMEOUT {
+			stat.TimedOut = stat.TimedOut + 1
+		}
+
+		if status == CANCELLED {
+			stat.Cancelled = stat.Cancelled + 1
+		}
+
+		if it := statusParams.Iteration; it > 0 {
+			// in a loop - so progress is for the latest iteration
+			is := stat.iteration(it)
+			is.Complete++
+			if status == FAIL {
+				is.Failed++
+			}
+			if status == TIMEOUT {
+				is.TimedOut++
+			}
+			is.PercentComplete = percent(is.Complete, f.TotalThreads)
 
-		// if we are in a loop
-		if stat.Complete > f.TotalThreads {
-			stat.PercentComplete /= stat.Complete
+			if it >= stat.Iteration {
+				stat.Iteration = it
+				stat.PercentComplete = is.PercentComplete
+			}
+		} else {
+			stat.PercentComplete = percent(stat.Complete, f.TotalThreads)
+		}
+
+		// time it unless the run was stopped under it
+		if status != CANCELLED && !statusParams.Started.IsZero() && !statusParams.Ended.IsZero() {
+			stat.Latency.Record(statusParams.Started, statusParams.Ended)
 		}
 
 		res.EndParam = statusParams
+
+		if statusParams.ThreadId == 0 {
+			for k, v := range statusParams.Outputs {
+				f.Outputs[OutputKey(id, k)] = v
+			}
+		}
+
+		if c := f.cell(statusParams.ThreadId); c != nil {
+			for k, v := range statusParams.Outputs {
+				c.Outputs[OutputKey(id, k)] = v
+			}
+			if status != SUCCESS && status != LOOP && !c.failed(id) {
+				c.Failed = append(c.Failed, id)
+			}
+		}
 		glog.Info("setting the endparams <<<<<<<<<<<<")
 
 	} else {
@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_TailOutput(t *testing.T) {
+	res := NewFlowLaunchResult(1)
+	stats, err := res.AddTask("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines, more, ok := res.TailOutput("build", 0)
+	if !ok || len(lines) != 0 {
+		t.Fatal("expected no output yet", lines)
+	}
+
+	fmt.Fprintln(stats.CommandStream, "one")
+	fmt.Fprintln(stats.CommandStream, "two")
+
+	select {
+	case <-more:
+	case <-time.After(time.Second):
+		t.Fatal("not told about more output")
+	}
+
+	// the scanner may still be adding the second line
+	for i := 0; i < 100 && len(lines) < 2; i++ {
+		lines, more, _ = res.TailOutput("build", 0)
+		time.Sleep(time.Millisecond)
+	}
+	if len(lines) != 2 || lines[0] != "one" || lines[1] != "two" {
+		t.Fatal("bad output", lines)
+	}
+
+	// carry on from the cursor
+	fmt.Fprintln(stats.CommandStream, "three")
+	<-more
+	lines, _, _ = res.TailOutput("build", 2)
+	if len(lines) != 1 || lines[0] != "three" {
+		t.Error("expected just the new line", lines)
+	}
+	if lines, _, _ = res.TailOutput("build", 10); len(lines) != 0 {
+		t.Error("expected nothing past the end", lines)
+	}
+
+	if _, _, ok := res.TailOutput("nope", 0); ok {
+		t.Error("expected no output for a missing task")
+	}
+}
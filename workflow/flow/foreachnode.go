@@ -0,0 +1,368 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// how a for each node gets its items and runs them
+type ForEachSpec struct {
+	Items     string                  // the prop holding the list of items e.g. the ls.files output of an ls task
+	Separator string                  // between the items - default is a new line, blank items are skipped
+	Parallel  int                     // the most items that run at the same time - zero means all of them
+	Body      func(w *Workflow) error // builds the sub graph run for each item - it must set the start and end of w
+}
+
+// A for each node runs its body once for every item in a list that is only known at run time.
+// Each item gets a fresh workflow made by the spec's Body with the <foreach-id>.item and
+// <foreach-id>.index props set. The body tasks share the stepper of the parent flow and their
+// results are reported as <foreach-id>.<node-id> - so the nodes of a for each in the body are
+// <foreach-id>.<inner-id>.<node-id>. When all the items have finished the node
+// completes with SUCCESS if they all succeeded otherwise FAIL, and publishes the outputs count,
+// succeeded, failed and failed-items, plus <index>.<key> for each output of each item.
+type ForEachNode struct {
+	name     string
+	id       string
+	flow     *Workflow
+	C        chan *Params
+	spec     ForEachSpec
+	template *Workflow // the body built once to check it and find its nodes
+	Outs     map[int][]TriggeredTaskNode
+	merges   []*MergeNode
+	streams  map[string]*io.PipeWriter // by body node id
+}
+
+func (tn *ForEachNode) SetMergeTrigger(m *MergeNode) {
+	tn.merges = append(tn.merges, m)
+}
+
+func (tn *ForEachNode) SetWorkFlow(f *Workflow) {
+	tn.flow = f
+}
+
+func (tn *ForEachNode) WorkFlow() *Workflow {
+	return tn.flow
+}
+
+func (tn *ForEachNode) DoneChan() chan *Params {
+	return tn.C
+}
+
+func (tn *ForEachNode) FireDoneChan(p *Params) {
+	tn.C <- p
+}
+
+func (tn *ForEachNode) Name() string {
+	return tn.name
+}
+
+func (tn *ForEachNode) Id() string {
+	return tn.id
+}
+
+func (tn *ForEachNode) Type() string {
+	return "foreach"
+}
+
+// the body nodes have their own streams
+func (tn *ForEachNode) SetStream(cs *io.PipeWriter) {}
+
+func (tn *ForEachNode) Spec() ForEachSpec {
+	return tn.spec
+}
+
+// add a node to fire when all the items are done with the given status
+func (tn *ForEachNode) AddNext(forStatus int, t TriggeredTaskNode) error {
+	if tn.flow == nil {
+		return errors.New("can't add next nodes if current flow not set")
+	}
+
+	if t.WorkFlow() != tn.flow {
+		panic("next nodes have to be in the same workflow")
+	}
+
+	if tn.Outs == nil {
+		tn.Outs = make(map[int][]TriggeredTaskNode)
+	}
+
+	tn.flow.registerNode(t)
+	tn.Outs[forStatus] = append(tn.Outs[forStatus], t)
+
+	return nil
+}
+
+func (tn *ForEachNode) Edges() []Edge {
+	edges := make([]Edge, 0, 1)
+	for val, x := range tn.Outs {
+		for _, xi := range x {
+			edges = append(edges, Edge{Name: fmt.Sprintf("%v", val), Status: val, From: tn.Id(), To: xi.Id()})
+		}
+	}
+	return edges
+}
+
+// the id the results of a body node are reported with
+func (tn *ForEachNode) bodyId(id string) string {
+	return OutputKey(tn.Id(), id)
+}
+
+// the ids of the body nodes in the parents results - including the nodes of any for each in the body
+func (tn *ForEachNode) subNodeIds() []string {
+	ids := []string{}
+	for id, n := range tn.template.TaskNodes {
+		ids = append(ids, tn.bodyId(id))
+		if sn, ok := n.(subNodeRunner); ok {
+			for _, sid := range sn.subNodeIds() {
+				ids = append(ids, tn.bodyId(sid))
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (tn *ForEachNode) setSubStream(id string, cs *io.PipeWriter) {
+	tn.streams[id] = cs
+}
+
+func (tn *ForEachNode) items(p *Params) []string {
+	sep := tn.spec.Separator
+	if sep == "" {
+		sep = "\n"
+	}
+	items := []string{}
+	for _, it := range strings.Split(p.Props[tn.spec.Items], sep) {
+		if strings.TrimSpace(it) != "" {
+			items = append(items, it)
+		}
+	}
+	return items
+}
+
+func (tn *ForEachNode) Exec(inPar *Params) {
+	if inPar == nil {
+		glog.Error("ooo - you cant have null parameters")
+		return
+	}
+
+	curPar := MakeParams()
+	curPar.Copy(inPar)
+	curPar.TaskName = tn.Name()
+	curPar.TaskId = tn.Id()
+	curPar.TaskType = tn.Type()
+
+	// wait for the stepper (or the debugger) - unless the flow was stopped in the meantime
+	if !tn.flow.waitStep(curPar) {
+		curPar.Status = CANCELLED
+		curPar.Response = "flow stopped"
+		curPar.Complete = true
+		select {
+		case tn.flow.End.DoneChan() <- curPar:
+		default:
+		}
+		return
+	}
+
+	// mark the start
+	curPar.Started = time.Now()
+	startPar := MakeParams()
+	startPar.Copy(curPar)
+	startPar.Started = curPar.Started
+	tn.flow.send(startPar)
+
+	items := tn.items(inPar)
+	glog.Info("for each ", tn.Id(), " over ", len(items), " items")
+
+	parallel := tn.spec.Parallel
+	if parallel <= 0 || parallel > len(items) {
+		parallel = len(items)
+	}
+	slots := make(chan struct{}, parallel)
+
+	results := make([]*Params, len(items))
+	wg := sync.WaitGroup{}
+	for i, item := range items {
+		select {
+		case slots <- struct{}{}:
+		case <-tn.flow.Context().Done():
+		}
+		if tn.flow.Cancelled() {
+			break
+		}
+		wg.Add(1)
+		go func(i int, item string) {
+			defer wg.Done()
+			results[i] = tn.runItem(i, item, curPar)
+			<-slots
+		}(i, item)
+	}
+	wg.Wait()
+
+	curPar.Ended = time.Now()
+	curPar.Complete = true
+
+	if tn.flow.Cancelled() {
+		curPar.Status = CANCELLED
+		select {
+		case tn.flow.End.DoneChan() <- curPar:
+		default:
+		}
+		return
+	}
+
+	tn.aggregate(curPar, items, results)
+	curPar.publishOutputs(tn.Id())
+
+	glog.Info("for each ", tn.Id(), " finished with status ", curPar.Status)
+	tn.flow.send(curPar)
+
+	if tn.C != nil {
+		if len(tn.C) > 0 {
+			<-tn.C
+		}
+		tn.C <- curPar
+	}
+
+	for _, m := range tn.merges {
+		m.arrive(tn.Id(), curPar)
+	}
+
+	next := tn.Outs[curPar.Status]
+	for _, n := range next {
+		go n.Exec(curPar)
+	}
+
+	if len(next) == 0 && len(tn.merges) == 0 && TriggeredTaskNode(tn) != tn.flow.End {
+		glog.Warning("problem - dead end for each - this workflow may not of ended properly")
+		tn.flow.End.FireDoneChan(curPar)
+	}
+}
+
+// run the body for one item and return the params it ended with
+func (tn *ForEachNode) runItem(i int, item string, inPar *Params) *Params {
+	fail := func(err error) *Params {
+		p := MakeParams()
+		p.Copy(inPar)
+		p.Status = FAIL
+		p.Response = err.Error()
+		return p
+	}
+
+	child := MakeWorkflow()
+	child.Name = tn.flow.Name
+	if err := tn.spec.Body(child); err != nil {
+		return fail(err)
+	}
+	if child.Start == nil || child.End == nil {
+		return fail(errors.New("for each body has no start or end"))
+	}
+
+	// run as part of the parent flow
+	child.Stepper = tn.flow.Stepper
+	child.debugger = tn.flow.debugger
+	child.runId = tn.flow.runId
+	child.ctx, child.cancel = context.WithCancel(tn.flow.Context())
+	defer child.Cancel()
+
+	if inPar.ThreadId == 0 {
+		for id, n := range child.TaskNodes {
+			if cs := tn.streams[tn.bodyId(id)]; cs != nil {
+				n.SetStream(cs)
+			}
+			if sn, ok := n.(subNodeRunner); ok {
+				for _, sid := range sn.subNodeIds() {
+					if cs := tn.streams[tn.bodyId(sid)]; cs != nil {
+						sn.setSubStream(sid, cs)
+					}
+				}
+			}
+		}
+	}
+
+	// forward the body status to the parent under our id until the item ends - then body nodes still
+	// going are stopped and anything they send is dropped so they don't block
+	child.quit = make(chan struct{})
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for {
+			select {
+			case st := <-child.C:
+				fp := *st
+				fp.TaskId = tn.bodyId(st.TaskId)
+				tn.flow.send(&fp)
+			case <-child.quit:
+				return
+			}
+		}
+	}()
+
+	p := MakeParams()
+	p.Copy(inPar)
+	p.Props[OutputKey(tn.Id(), "item")] = item
+	p.Props[OutputKey(tn.Id(), "index")] = strconv.Itoa(i)
+
+	go child.Exec(p)
+
+	var end *Params
+	select {
+	case end = <-child.End.DoneChan():
+	case <-tn.flow.Context().Done():
+		end = fail(errors.New("flow stopped"))
+		end.Status = CANCELLED
+	}
+
+	close(child.quit)
+	<-forwarded
+
+	// the outputs of the body - anything the item added to the props
+	res := MakeParams()
+	res.Copy(end)
+	res.Status = end.Status
+	res.Response = end.Response
+	res.Outputs = Props{}
+	for k, v := range end.Props {
+		if _, ok := p.Props[k]; !ok {
+			res.Outputs[k] = v
+		}
+	}
+	return res
+}
+
+// combine the item results into our params
+func (tn *ForEachNode) aggregate(curPar *Params, items []string, results []*Params) {
+	succeeded := 0
+	failed := []string{}
+	for i, r := range results {
+		if r == nil {
+			continue
+		}
+		if r.Status == SUCCESS {
+			succeeded++
+		} else {
+			failed = append(failed, items[i])
+		}
+		for k, v := range r.Outputs {
+			curPar.SetOutput(OutputKey(strconv.Itoa(i), k), v)
+		}
+	}
+
+	curPar.SetOutput("count", strconv.Itoa(len(results)))
+	curPar.SetOutput("succeeded", strconv.Itoa(succeeded))
+	curPar.SetOutput("failed", strconv.Itoa(len(failed)))
+	curPar.SetOutput("failed-items", strings.Join(failed, "\n"))
+
+	curPar.Status = SUCCESS
+	if len(failed) > 0 {
+		curPar.Status = FAIL
+	}
+}
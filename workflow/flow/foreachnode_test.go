@@ -0,0 +1,235 @@
+package flow
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// outputs the list of items
+type listTask struct{}
+
+func (t listTask) Type() string { return "list" }
+
+func (t listTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	p.SetOutput("items", "a\nb\n\nc\nfail")
+	p.Status = SUCCESS
+}
+
+// echos the item - fails on the fail item and records how many run at once
+type itemTask struct {
+	running, most int32
+}
+
+func (t *itemTask) Type() string { return "item" }
+
+func (t *itemTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	n := atomic.AddInt32(&t.running, 1)
+	defer atomic.AddInt32(&t.running, -1)
+	for {
+		m := atomic.LoadInt32(&t.most)
+		if n <= m || atomic.CompareAndSwapInt32(&t.most, m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	item := p.Props["fe.item"]
+	p.SetOutput("v", item)
+	p.Status = SUCCESS
+	if item == "fail" {
+		p.Status = FAIL
+	}
+}
+
+func Test_ForEach(t *testing.T) {
+	it := &itemTask{}
+
+	w := MakeWorkflow()
+	w.Name = "foreach"
+	s := w.MakeTaskNode("s", listTask{})
+	fe, err := w.MakeForEachNode("fe", ForEachSpec{
+		Items:    "s.items",
+		Parallel: 2,
+		Body: func(bw *Workflow) error {
+			b := bw.MakeTaskNode("b", it)
+			bw.SetStart(b)
+			bw.SetEnd(b)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := w.MakeTaskNode("e", nopTask{})
+	s.AddNext(SUCCESS, fe)
+	fe.AddNext(FAIL, e)
+	w.SetStart(s)
+	w.SetEnd(e)
+
+	if ids := fe.subNodeIds(); len(ids) != 1 || ids[0] != "fe.b" {
+		t.Error("bad body ids", ids)
+	}
+
+	statuses, end := runTestFlow(t, w, e)
+
+	if it.most != 2 {
+		t.Error("parallel limit not used", it.most)
+	}
+
+	body := 0
+	for _, p := range statuses {
+		if p.TaskId == "fe.b" {
+			body++
+		}
+	}
+	if body != 4 {
+		t.Error("body results should be reported as the for each", body)
+	}
+
+	props := end.Props
+	if props["fe.count"] != "4" || props["fe.succeeded"] != "3" || props["fe.failed-items"] != "fail" {
+		t.Error("bad aggregate", props)
+	}
+	if props["fe.1.b.v"] != "b" || props["fe.3.b.v"] != "fail" {
+		t.Error("item outputs not published", props)
+	}
+}
+
+// outputs a line of comma separated items for each outer item
+type pairsTask struct{}
+
+func (t pairsTask) Type() string { return "pairs" }
+
+func (t pairsTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	p.SetOutput("pairs", "1,2\n3")
+	p.Status = SUCCESS
+}
+
+// echos the item of the inner for each
+type innerTask struct{}
+
+func (t innerTask) Type() string { return "inner" }
+
+func (t innerTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	p.SetOutput("v", p.Props["in.item"])
+	p.Status = SUCCESS
+}
+
+type nestedFlow struct {
+	childFlow
+}
+
+func (c *nestedFlow) FlowFunc(threadId int) *Workflow {
+	w := MakeWorkflow()
+	s := w.MakeTaskNode("s", pairsTask{})
+	fe, _ := w.MakeForEachNode("fe", ForEachSpec{
+		Items: "s.pairs",
+		Body: func(bw *Workflow) error {
+			in, err := bw.MakeForEachNode("in", ForEachSpec{
+				Items:     "fe.item",
+				Separator: ",",
+				Body: func(iw *Workflow) error {
+					b := iw.MakeTaskNode("b", innerTask{})
+					iw.SetStart(b)
+					iw.SetEnd(b)
+					return nil
+				},
+			})
+			if err != nil {
+				return err
+			}
+			a := bw.MakeTaskNode("a", nopTask{})
+			a.AddNext(SUCCESS, in)
+			bw.SetStart(a)
+			bw.SetEnd(in)
+			return nil
+		},
+	})
+	e := w.MakeTaskNode("e", nopTask{})
+	s.AddNext(SUCCESS, fe)
+	fe.AddNext(SUCCESS, e)
+	w.SetStart(s)
+	w.SetEnd(e)
+	return w
+}
+
+func Test_NestedForEach(t *testing.T) {
+	nf := &nestedFlow{childFlow{dir: t.TempDir()}}
+	nf.Init("nested")
+	l := MakeFlowLauncher(nf, 1, nil, nil)
+
+	fe := nf.FlowFunc(0).TaskNodes["fe"].(*ForEachNode)
+	if ids := fe.subNodeIds(); len(ids) != 3 || ids[1] != "fe.in" || ids[2] != "fe.in.b" {
+		t.Error("nested body ids not registered", ids)
+	}
+
+	ec := make(chan *Params, 1)
+	r := l.StartRun(time.Millisecond, nil, ec)
+	var end *Params
+	select {
+	case end = <-ec:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nested for each did not finish")
+	}
+
+	if end.Status != SUCCESS {
+		t.Error("nested for each failed", end.Status, end.Response)
+	}
+	res := r.CurrentResult()
+	for _, id := range []string{"fe.in", "fe.in.b"} {
+		if to, ok := res.TaskOutput(id); !ok || to == nil {
+			t.Error("no result for nested body node ", id)
+		}
+	}
+	to, _ := res.TaskOutput("fe")
+	if to.Outputs["fe.0.in.1.b.v"] != "2" || to.Outputs["fe.1.in.0.b.v"] != "3" {
+		t.Error("nested outputs not published", to.Outputs)
+	}
+}
+
+func Test_ForEachNoLeaks(t *testing.T) {
+	run := func() {
+		w := MakeWorkflow()
+		w.Name = "leaks"
+		s := w.MakeTaskNode("s", listTask{})
+		fe, err := w.MakeForEachNode("fe", ForEachSpec{
+			Items: "s.items",
+			Body: func(bw *Workflow) error {
+				// the item ends at b but late still goes on after it
+				b := bw.MakeTaskNode("b", nopTask{})
+				late := bw.MakeTaskNode("late", nopTask{})
+				b.AddNext(SUCCESS, late)
+				bw.SetStart(b)
+				bw.SetEnd(b)
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := w.MakeTaskNode("e", nopTask{})
+		s.AddNext(SUCCESS, fe)
+		fe.AddNext(SUCCESS, e)
+		w.SetStart(s)
+		w.SetEnd(e)
+		runTestFlow(t, w, e)
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		run()
+	}
+
+	// everything the items started has gone
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Error("for each leaked goroutines", n-before)
+	}
+}
@@ -0,0 +1,336 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// how a gate asks for approval
+type GateSpec struct {
+	Message string        // shown to whoever approves - can use ${...} variables
+	Timeout time.Duration // reject the gate if no one has decided in time - zero means wait forever
+}
+
+// the decision made on an approval
+type Decision struct {
+	Approved bool
+	User     string
+	Comment  string
+}
+
+// a gate waiting for someone to approve or reject it
+type Approval struct {
+	Id        string
+	FlowName  string
+	RunId     string
+	ThreadId  int
+	TaskId    string
+	TaskName  string
+	Message   string
+	Requested time.Time
+	Deadline  time.Time // when it will be rejected - zero if it waits forever
+	decide    chan Decision
+}
+
+// the set of approvals waiting for a decision
+type ApprovalRegistry struct {
+	lock    sync.Mutex
+	seq     int
+	pending map[string]*Approval
+}
+
+// all gates register here unless they are given another registry
+var Approvals = NewApprovalRegistry()
+
+func NewApprovalRegistry() *ApprovalRegistry {
+	return &ApprovalRegistry{
+		pending: map[string]*Approval{},
+	}
+}
+
+// the approvals waiting for a decision - oldest first
+func (r *ApprovalRegistry) Pending() []*Approval {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	all := make([]*Approval, 0, len(r.pending))
+	for _, a := range r.pending {
+		all = append(all, a)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Requested.Before(all[j].Requested)
+	})
+	return all
+}
+
+// approve or reject the approval with this id
+func (r *ApprovalRegistry) Decide(id string, d Decision) error {
+	r.lock.Lock()
+	a, ok := r.pending[id]
+	delete(r.pending, id)
+	r.lock.Unlock()
+
+	if !ok {
+		return errors.New("no pending approval: " + id)
+	}
+	a.decide <- d
+	return nil
+}
+
+func (r *ApprovalRegistry) add(a *Approval) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.seq++
+	a.Id = fmt.Sprintf("%s-%d-%s-%d", MakeID(a.FlowName), a.ThreadId, a.TaskId, r.seq)
+	a.decide = make(chan Decision, 1) // so a decision never blocks if the gate has given up
+	r.pending[a.Id] = a
+}
+
+// take it away - returns false if it had already been decided
+func (r *ApprovalRegistry) remove(a *Approval) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, ok := r.pending[a.Id]
+	delete(r.pending, a.Id)
+	return ok
+}
+
+// A gate node parks its thread with the WAITING status until someone approves or rejects it through
+// its registry. It completes with SUCCESS when approved and FAIL when rejected or timed out and
+// publishes the approved, user and comment outputs.
+type GateNode struct {
+	name      string
+	id        string
+	flow      *Workflow
+	C         chan *Params
+	spec      GateSpec
+	registry  *ApprovalRegistry
+	Outs      map[int][]TriggeredTaskNode
+	merges    []*MergeNode
+	runLock   sync.Mutex
+	cancelRun context.CancelFunc
+}
+
+func (tn *GateNode) SetMergeTrigger(m *MergeNode) {
+	tn.merges = append(tn.merges, m)
+}
+
+func (tn *GateNode) SetWorkFlow(f *Workflow) {
+	tn.flow = f
+}
+
+func (tn *GateNode) WorkFlow() *Workflow {
+	return tn.flow
+}
+
+func (tn *GateNode) DoneChan() chan *Params {
+	return tn.C
+}
+
+func (tn *GateNode) FireDoneChan(p *Params) {
+	tn.C <- p
+}
+
+func (tn *GateNode) Name() string {
+	return tn.name
+}
+
+func (tn *GateNode) Id() string {
+	return tn.id
+}
+
+func (tn *GateNode) Type() string {
+	return "gate"
+}
+
+func (tn *GateNode) SetStream(cs *io.PipeWriter) {}
+
+// use a registry other than Approvals
+func (tn *GateNode) SetRegistry(r *ApprovalRegistry) {
+	tn.registry = r
+}
+
+// stop waiting - e.g. a merge node no longer needs it
+func (tn *GateNode) Cancel() {
+	tn.runLock.Lock()
+	defer tn.runLock.Unlock()
+	if tn.cancelRun != nil {
+		tn.cancelRun()
+	}
+}
+
+// add the node to fire on approval (SUCCESS) or rejection (FAIL)
+func (tn *GateNode) AddNext(forStatus int, t TriggeredTaskNode) error {
+	if tn.flow == nil {
+		return errors.New("can't add next nodes if current flow not set")
+	}
+
+	if t.WorkFlow() != tn.flow {
+		panic("next nodes have to be in the same workflow")
+	}
+
+	if tn.Outs == nil {
+		tn.Outs = make(map[int][]TriggeredTaskNode)
+	}
+
+	tn.flow.registerNode(t)
+	tn.Outs[forStatus] = append(tn.Outs[forStatus], t)
+
+	return nil
+}
+
+func (tn *GateNode) Edges() []Edge {
+	edges := make([]Edge, 0, 1)
+	for val, x := range tn.Outs {
+		for _, xi := range x {
+			edges = append(edges, Edge{Name: fmt.Sprintf("%v", val), Status: val, From: tn.Id(), To: xi.Id()})
+		}
+	}
+	return edges
+}
+
+func (tn *GateNode) Exec(inPar *Params) {
+	if inPar == nil {
+		glog.Error("ooo - you cant have null parameters")
+		return
+	}
+
+	curPar := MakeParams()
+	curPar.Copy(inPar)
+	curPar.TaskName = tn.Name()
+	curPar.TaskId = tn.Id()
+	curPar.TaskType = tn.Type()
+
+	// wait for the stepper (or the debugger) - unless the flow was stopped in the meantime
+	if !tn.flow.waitStep(curPar) {
+		curPar.Status = CANCELLED
+		curPar.Response = "flow stopped"
+		curPar.Complete = true
+		select {
+		case tn.flow.End.DoneChan() <- curPar:
+		default:
+		}
+		return
+	}
+
+	curPar.Started = time.Now()
+	tn.wait(curPar)
+	curPar.Ended = time.Now()
+	curPar.publishOutputs(tn.Id())
+	curPar.Complete = true
+
+	if tn.flow.Cancelled() {
+		curPar.Status = CANCELLED
+		select {
+		case tn.flow.End.DoneChan() <- curPar:
+		default:
+		}
+		return
+	}
+
+	glog.Info("gate ", tn.Id(), " finished with status ", curPar.Status)
+	tn.flow.send(curPar)
+
+	if tn.C != nil {
+		if len(tn.C) > 0 {
+			<-tn.C
+		}
+		tn.C <- curPar
+	}
+
+	for _, m := range tn.merges {
+		m.arrive(tn.Id(), curPar)
+	}
+
+	next := tn.Outs[curPar.Status]
+	for _, n := range next {
+		go n.Exec(curPar)
+	}
+
+	if len(next) == 0 && len(tn.merges) == 0 && TriggeredTaskNode(tn) != tn.flow.End {
+		glog.Warning("problem - dead end gate - this workflow may not of ended properly")
+		tn.flow.End.FireDoneChan(curPar)
+	}
+}
+
+// register the approval and wait for the decision
+func (tn *GateNode) wait(curPar *Params) {
+	msg, err := curPar.Expand(tn.spec.Message)
+	if err != nil {
+		curPar.Status = FAIL
+		curPar.Response = err.Error()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(tn.flow.Context())
+	defer cancel()
+
+	tn.runLock.Lock()
+	tn.cancelRun = cancel
+	tn.runLock.Unlock()
+
+	a := &Approval{
+		FlowName:  curPar.FlowName,
+		RunId:     tn.flow.runId,
+		ThreadId:  curPar.ThreadId,
+		TaskId:    tn.Id(),
+		TaskName:  tn.Name(),
+		Message:   msg,
+		Requested: time.Now(),
+	}
+
+	var timeout <-chan time.Time
+	if tn.spec.Timeout > 0 {
+		a.Deadline = a.Requested.Add(tn.spec.Timeout)
+		timeout = time.After(tn.spec.Timeout)
+	}
+
+	tn.registry.add(a)
+	glog.Info("gate ", tn.Id(), " waiting for approval ", a.Id)
+
+	// park the thread
+	waitPar := MakeParams()
+	waitPar.Copy(curPar)
+	waitPar.Status = WAITING
+	waitPar.Response = "waiting for approval " + a.Id
+	tn.flow.send(waitPar)
+
+	var d Decision
+	select {
+	case d = <-a.decide:
+	case <-timeout:
+		if !tn.registry.remove(a) {
+			d = <-a.decide // it was decided as we timed out
+			break
+		}
+		d = Decision{Comment: fmt.Sprintf("no decision after %v", tn.spec.Timeout)}
+	case <-ctx.Done():
+		tn.registry.remove(a)
+		curPar.Status = CANCELLED
+		curPar.Response = "gate stopped"
+		return
+	}
+
+	curPar.SetOutput("approved", strconv.FormatBool(d.Approved))
+	curPar.SetOutput("user", d.User)
+	curPar.SetOutput("comment", d.Comment)
+
+	if d.Approved {
+		curPar.Status = SUCCESS
+		curPar.Response = "approved by " + d.User
+		return
+	}
+
+	curPar.Status = FAIL
+	curPar.Response = "rejected"
+	if d.User != "" {
+		curPar.Response += " by " + d.User
+	}
+}
@@ -0,0 +1,94 @@
+package flow
+
+import (
+	"testing"
+	"time"
+)
+
+func gateFlow(spec GateSpec, r *ApprovalRegistry) (*Workflow, *TaskNode) {
+	w := MakeWorkflow()
+	w.Name = "gate"
+	s := w.MakeTaskNode("s", nopTask{})
+	g := w.MakeGateNode("g", spec)
+	g.SetRegistry(r)
+	e := w.MakeTaskNode("e", nopTask{})
+	s.AddNext(SUCCESS, g)
+	g.AddNext(SUCCESS, e)
+	g.AddNext(FAIL, e)
+	w.SetStart(s)
+	w.SetEnd(e)
+	return w, e
+}
+
+func Test_GateApprove(t *testing.T) {
+	r := NewApprovalRegistry()
+	w, e := gateFlow(GateSpec{Message: "deploy ${flow-name}?"}, r)
+	w.runId = "gate-1"
+
+	// approve it as soon as it is waiting
+	go func() {
+		for {
+			if p := r.Pending(); len(p) > 0 {
+				if p[0].Message != "deploy gate?" || p[0].TaskId != "g" || p[0].RunId != "gate-1" {
+					t.Error("bad approval", p[0])
+				}
+				if err := r.Decide(p[0].Id, Decision{Approved: true, User: "sam", Comment: "lgtm"}); err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	statuses, end := runTestFlow(t, w, e)
+
+	if end.Props["g.approved"] != "true" || end.Props["g.user"] != "sam" || end.Props["g.comment"] != "lgtm" {
+		t.Error("decision not recorded", end.Props)
+	}
+
+	for _, p := range statuses {
+		if p.TaskId == "g" && p.Status != SUCCESS {
+			t.Error("gate should be approved", p.Status)
+		}
+	}
+
+	if len(r.Pending()) != 0 {
+		t.Error("approval still pending")
+	}
+	if err := r.Decide("nope", Decision{}); err == nil {
+		t.Error("expected an error deciding an unknown approval")
+	}
+}
+
+func Test_GateTimeout(t *testing.T) {
+	r := NewApprovalRegistry()
+	w, e := gateFlow(GateSpec{Timeout: 10 * time.Millisecond}, r)
+
+	statuses, end := runTestFlow(t, w, e)
+
+	if end.Props["g.approved"] != "false" {
+		t.Error("timeout should reject", end.Props)
+	}
+	for _, p := range statuses {
+		if p.TaskId == "g" && p.Status != FAIL {
+			t.Error("gate should be rejected", p.Status)
+		}
+	}
+
+	res := NewFlowLaunchResult(1)
+	res.AddTask("g")
+	p := MakeParams()
+	p.TaskId = "g"
+	p.Status = WAITING
+	res.AddStatusOrResult(p)
+	if res.Results["g"].Stats.Waiting != 1 {
+		t.Error("thread should be waiting")
+	}
+	p.Complete = true
+	p.Status = SUCCESS
+	res.AddStatusOrResult(p)
+	if res.Results["g"].Stats.Waiting != 0 {
+		t.Error("thread should not be waiting")
+	}
+}
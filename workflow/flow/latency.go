@@ -0,0 +1,122 @@
+package flow
+
+import (
+	"time"
+)
+
+// the upper bounds of the histogram buckets - doubling from a millisecond to over an hour, anything
+// longer goes in a last bucket with no bound
+var latencyBounds = func() []time.Duration {
+	bounds := []time.Duration{}
+	for d := time.Millisecond; d <= 2*time.Hour; d *= 2 {
+		bounds = append(bounds, d)
+	}
+	return bounds
+}()
+
+// a bucket of the histogram - the executions that took longer than the previous buckets UpTo and no
+// more than this UpTo, zero means no upper bound
+type LatencyBucket struct {
+	UpTo  time.Duration
+	Count int
+}
+
+// LatencyStats times every execution of a task across all threads. Only the totals and a fixed set of
+// buckets are kept so the memory used is the same for one execution or a million. The percentiles are
+// estimated from the buckets, so are accurate to within a bucket, and kept up to date on each record.
+type LatencyStats struct {
+	Count      int
+	Min        time.Duration
+	Max        time.Duration
+	Mean       time.Duration
+	Total      time.Duration
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	FirstStart time.Time // the earliest any execution started
+	LastEnd    time.Time // the latest any execution ended
+	Histogram  []LatencyBucket
+}
+
+func NewLatencyStats() *LatencyStats {
+	l := &LatencyStats{
+		Histogram: make([]LatencyBucket, len(latencyBounds)+1),
+	}
+	for i, b := range latencyBounds {
+		l.Histogram[i].UpTo = b
+	}
+	return l
+}
+
+// add the timing of one execution
+func (l *LatencyStats) Record(start, end time.Time) {
+	d := end.Sub(start)
+	if d < 0 {
+		d = 0
+	}
+
+	if l.Count == 0 || d < l.Min {
+		l.Min = d
+	}
+	if d > l.Max {
+		l.Max = d
+	}
+	l.Count++
+	l.Total += d
+	l.Mean = l.Total / time.Duration(l.Count)
+
+	if l.FirstStart.IsZero() || start.Before(l.FirstStart) {
+		l.FirstStart = start
+	}
+	if end.After(l.LastEnd) {
+		l.LastEnd = end
+	}
+
+	l.Histogram[l.bucket(d)].Count++
+
+	l.P50 = l.Percentile(50)
+	l.P90 = l.Percentile(90)
+	l.P99 = l.Percentile(99)
+}
+
+func (l *LatencyStats) bucket(d time.Duration) int {
+	for i, b := range latencyBounds {
+		if d <= b {
+			return i
+		}
+	}
+	return len(latencyBounds)
+}
+
+// estimate the duration that p percent of the executions took no longer than - by interpolating
+// within the bucket it falls in
+func (l *LatencyStats) Percentile(p float64) time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+
+	rank := p / 100 * float64(l.Count)
+	seen := 0
+	for i, b := range l.Histogram {
+		if b.Count == 0 || float64(seen+b.Count) < rank {
+			seen += b.Count
+			continue
+		}
+
+		// the range of the bucket - narrowed to what was actually seen
+		lo, hi := time.Duration(0), b.UpTo
+		if i > 0 {
+			lo = l.Histogram[i-1].UpTo
+		}
+		if lo < l.Min {
+			lo = l.Min
+		}
+		if hi == 0 || hi > l.Max {
+			hi = l.Max
+		}
+
+		frac := (rank - float64(seen)) / float64(b.Count)
+		return lo + time.Duration(frac*float64(hi-lo))
+	}
+	return l.Max
+}
@@ -0,0 +1,126 @@
+package flow
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func Test_LatencyStats(t *testing.T) {
+	l := NewLatencyStats()
+	if l.Percentile(50) != 0 {
+		t.Error("expected no percentile with no samples")
+	}
+
+	start := time.Now()
+	// 1ms to 100ms
+	for i := 1; i <= 100; i++ {
+		l.Record(start, start.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	if l.Count != 100 || l.Min != time.Millisecond || l.Max != 100*time.Millisecond {
+		t.Error("bad count min or max", l.Count, l.Min, l.Max)
+	}
+	if l.Mean != 50500*time.Microsecond {
+		t.Error("bad mean", l.Mean)
+	}
+	if !l.FirstStart.Equal(start) || !l.LastEnd.Equal(start.Add(100*time.Millisecond)) {
+		t.Error("bad first start or last end", l.FirstStart, l.LastEnd)
+	}
+
+	// the estimate can only be out by the width of the bucket it is in
+	check := func(name string, got, want, slack time.Duration) {
+		if got < want-slack || got > want+slack {
+			t.Errorf("%s was %v expected about %v", name, got, want)
+		}
+	}
+	check("p50", l.P50, 50*time.Millisecond, 32*time.Millisecond)
+	check("p90", l.P90, 90*time.Millisecond, 36*time.Millisecond)
+	check("p99", l.P99, 99*time.Millisecond, 36*time.Millisecond)
+	if l.P50 > l.P90 || l.P90 > l.P99 || l.P99 > l.Max {
+		t.Error("percentiles out of order", l.P50, l.P90, l.P99, l.Max)
+	}
+
+	total := 0
+	for _, b := range l.Histogram {
+		total += b.Count
+	}
+	if total != 100 {
+		t.Error("histogram lost samples", total)
+	}
+
+	// the memory is bounded - a long execution goes in the last bucket
+	n := len(l.Histogram)
+	l.Record(start, start.Add(10*time.Hour))
+	if len(l.Histogram) != n || l.Histogram[n-1].Count != 1 || l.Percentile(100) != 10*time.Hour {
+		t.Error("long execution not in the open bucket")
+	}
+}
+
+type sleepTask struct {
+	d time.Duration
+}
+
+func (t sleepTask) Type() string { return "sleep" }
+
+func (t sleepTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	time.Sleep(t.d)
+	p.Status = SUCCESS
+}
+
+func Test_LatencyResults(t *testing.T) {
+	w := MakeWorkflow()
+	w.Name = "latency"
+	s := w.MakeTaskNode("s", sleepTask{d: 20 * time.Millisecond})
+	e := w.MakeTaskNode("e", nopTask{})
+	s.AddNext(SUCCESS, e)
+	w.SetStart(s)
+	w.SetEnd(e)
+
+	statuses, _ := runTestFlow(t, w, e)
+
+	res := NewFlowLaunchResult(1)
+	res.AddTask("s")
+	res.AddTask("e")
+	for _, p := range statuses {
+		res.AddStatusOrResult(p)
+	}
+
+	l := res.Results["s"].Stats.Latency
+	if l.Count != 1 || l.Min < 20*time.Millisecond {
+		t.Error("task not timed", l.Count, l.Min)
+	}
+	if end := res.Results["s"].EndParam; end.Started.IsZero() || end.Ended.Before(end.Started) {
+		t.Error("params not stamped", end.Started, end.Ended)
+	}
+	if res.Results["e"].Stats.Latency.Count != 1 {
+		t.Error("end task not timed")
+	}
+}
+
+func Test_LatencyRetried(t *testing.T) {
+	w := MakeWorkflow()
+	w.Name = "latency"
+	s := w.MakeTaskNode("s", &flakyTask{n: 2})
+	s.SetRetry(&RetryPolicy{MaxAttempts: 3, Delay: 20 * time.Millisecond})
+	e := w.MakeTaskNode("e", nopTask{})
+	s.AddNext(SUCCESS, e)
+	w.SetStart(s)
+	w.SetEnd(e)
+
+	statuses, _ := runTestFlow(t, w, e)
+
+	res := NewFlowLaunchResult(1)
+	res.AddTask("s")
+	res.AddTask("e")
+	for _, p := range statuses {
+		res.AddStatusOrResult(p)
+	}
+
+	// timed from the first attempt to the end of the last
+	l := res.Results["s"].Stats.Latency
+	if l.Count != 1 || l.Min < 20*time.Millisecond {
+		t.Error("retried task not timed", l.Count, l.Min)
+	}
+}
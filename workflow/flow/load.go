@@ -0,0 +1,203 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// how the threads of a load test are started
+const (
+	RAMP_NONE   = ""       // start every thread at once
+	RAMP_LINEAR = "linear" // start the threads evenly spread over RampUp
+	RAMP_STEP   = "step"   // start the threads in Steps equal groups, one group every StepEvery
+	RAMP_RATE   = "rate"   // start a run of the flow Rate times a second - Threads is the most that run at once
+)
+
+// A LoadProfile controls when the threads of a launcher start. With a Duration every thread keeps
+// running its flow again until the time is up - for the rate profile that is how long new runs keep
+// arriving, without one there are Threads runs in all. The number of flows running is sampled every
+// SampleEvery into the run results.
+type LoadProfile struct {
+	Ramp        string
+	RampUp      time.Duration
+	Steps       int
+	StepEvery   time.Duration
+	Rate        float64       // runs started per second
+	Duration    time.Duration // keep running until this long after the first thread started - zero means run each thread once
+	SampleEvery time.Duration // default is a second
+}
+
+// the number of flows running at a point in the run
+type ConcurrencySample struct {
+	At      time.Duration // since the run started
+	Running int
+}
+
+func (lp *LoadProfile) Validate() error {
+	if lp.Duration < 0 || lp.SampleEvery < 0 {
+		return errors.New("load durations can not be negative")
+	}
+	switch lp.Ramp {
+	case RAMP_NONE:
+	case RAMP_LINEAR:
+		if lp.RampUp <= 0 {
+			return errors.New("linear ramp needs a ramp up time")
+		}
+	case RAMP_STEP:
+		if lp.Steps <= 0 || lp.StepEvery <= 0 {
+			return errors.New("step ramp needs steps and a time between them")
+		}
+	case RAMP_RATE:
+		if lp.Rate <= 0 {
+			return errors.New("rate ramp needs a rate above zero")
+		}
+	default:
+		return errors.New("unknown ramp: " + lp.Ramp)
+	}
+	return nil
+}
+
+// when thread i of n starts after the run started - not used for the rate profile
+func (lp *LoadProfile) offset(i, n int) time.Duration {
+	switch lp.Ramp {
+	case RAMP_LINEAR:
+		return time.Duration(int64(lp.RampUp) * int64(i) / int64(n))
+	case RAMP_STEP:
+		per := (n + lp.Steps - 1) / lp.Steps
+		return time.Duration(i/per) * lp.StepEvery
+	}
+	return 0
+}
+
+// start the threads of the run according to the load profile and wait for them all to finish
+func (fl *FlowLauncher) execLoad(r *Run) {
+	lp := fl.load
+	start := time.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.lock.Lock()
+	r.stopLoad = cancel
+	r.lock.Unlock()
+
+	var deadline <-chan time.Time
+	if lp.Duration > 0 {
+		t := time.NewTimer(lp.Duration)
+		defer t.Stop()
+		deadline = t.C
+	}
+	timeUp := func() bool {
+		return lp.Duration > 0 && time.Since(start) >= lp.Duration
+	}
+
+	// keep track of the running flows and sample them - only on the tick so the samples stay bounded
+	running := int32(0)
+	sampleEvery := lp.SampleEvery
+	if sampleEvery == 0 {
+		sampleEvery = time.Second
+	}
+	stopSampling := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		tick := time.NewTicker(sampleEvery)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				r.Result.sample(time.Since(start), int(atomic.LoadInt32(&running)))
+			case <-stopSampling:
+				r.Result.sample(time.Since(start), int(atomic.LoadInt32(&running)))
+				return
+			}
+		}
+	}()
+
+	// one run of the flow on thread i - returns false if the run was stopped
+	runOnce := func(i int) bool {
+		r.Result.peak(int(atomic.AddInt32(&running, 1)))
+		fl.execOneFlow(r, i, nil, false)
+		atomic.AddInt32(&running, -1)
+
+		// the next run needs a fresh workflow
+		r.lock.Lock()
+		r.flows[i] = nil
+		r.lock.Unlock()
+
+		return ctx.Err() == nil
+	}
+
+	var wg sync.WaitGroup
+
+	if lp.Ramp == RAMP_RATE {
+		// hand out the thread ids that are free - so no more than Threads run at once
+		free := make(chan int, fl.Threads)
+		for i := 0; i < fl.Threads; i++ {
+			free <- i
+		}
+		every := time.Duration(float64(time.Second) / lp.Rate)
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+
+	arrivals:
+		for n := 0; lp.Duration > 0 || n < fl.Threads; n++ {
+			var i int
+			select {
+			case i = <-free:
+			case <-deadline:
+				break arrivals
+			case <-ctx.Done():
+				break arrivals
+			}
+			if timeUp() {
+				break
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				runOnce(i)
+				free <- i
+			}(i)
+
+			select {
+			case <-tick.C:
+			case <-deadline:
+				break arrivals
+			case <-ctx.Done():
+				break arrivals
+			}
+		}
+	} else {
+		for i := 0; i < fl.Threads; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				select {
+				case <-time.After(lp.offset(i, fl.Threads) - time.Since(start)):
+				case <-ctx.Done():
+					return
+				}
+				for !timeUp() {
+					if !runOnce(i) || lp.Duration == 0 {
+						return
+					}
+				}
+			}(i)
+		}
+	}
+
+	wg.Wait()
+	close(stopSampling)
+	<-sampled
+
+	r.lock.Lock()
+	r.stopLoad = nil
+	r.lock.Unlock()
+
+	glog.Info("load run ", r.Id, " finished after ", time.Since(start))
+}
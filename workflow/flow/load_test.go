@@ -0,0 +1,157 @@
+package flow
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_LoadProfileValidate(t *testing.T) {
+	good := []LoadProfile{
+		{},
+		{Duration: time.Second},
+		{Ramp: RAMP_LINEAR, RampUp: time.Second},
+		{Ramp: RAMP_STEP, Steps: 2, StepEvery: time.Second},
+		{Ramp: RAMP_RATE, Rate: 0.5},
+	}
+	for _, lp := range good {
+		if err := lp.Validate(); err != nil {
+			t.Error(lp, err)
+		}
+	}
+
+	bad := []LoadProfile{
+		{Duration: -time.Second},
+		{Ramp: RAMP_LINEAR},
+		{Ramp: RAMP_STEP, Steps: 2},
+		{Ramp: RAMP_RATE},
+		{Ramp: "sine"},
+	}
+	for _, lp := range bad {
+		if lp.Validate() == nil {
+			t.Error("expected load profile to be invalid", lp)
+		}
+	}
+}
+
+func Test_LoadOffsets(t *testing.T) {
+	lin := LoadProfile{Ramp: RAMP_LINEAR, RampUp: 400 * time.Millisecond}
+	for i, want := range []time.Duration{0, 100, 200, 300} {
+		if got := lin.offset(i, 4); got != want*time.Millisecond {
+			t.Error("linear thread", i, "starts at", got)
+		}
+	}
+
+	step := LoadProfile{Ramp: RAMP_STEP, Steps: 2, StepEvery: time.Second}
+	for i, want := range []time.Duration{0, 0, 0, 1, 1} {
+		if got := step.offset(i, 5); got != want*time.Second {
+			t.Error("step thread", i, "starts at", got)
+		}
+	}
+}
+
+type loadFlow struct {
+	childFlow
+}
+
+func (c *loadFlow) FlowFunc(threadId int) *Workflow {
+	w := MakeWorkflow()
+	s := w.MakeTaskNode("sleep", sleepTask{d: 10 * time.Millisecond})
+	w.SetStart(s)
+	w.SetEnd(s)
+	return w
+}
+
+// run the launcher with the load profile - returns the end params and how long it took
+func runLoad(t *testing.T, threads int, lp LoadProfile, stopAfter time.Duration) (*FlowLauncher, *Params, time.Duration) {
+	lf := &loadFlow{childFlow{dir: t.TempDir()}}
+	lf.Init("load")
+	l := MakeFlowLauncher(lf, threads, nil, nil)
+	if err := l.SetLoad(lp); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	ec := make(chan *Params, 1)
+	go l.Start(time.Millisecond, ec)
+
+	if stopAfter > 0 {
+		time.Sleep(stopAfter)
+		l.ExterminateExterminate()
+	}
+
+	select {
+	case end := <-ec:
+		return l, end, time.Since(start)
+	case <-time.After(5 * time.Second):
+		t.Fatal("load run did not end")
+	}
+	return nil, nil, 0
+}
+
+func Test_LoadRamp(t *testing.T) {
+	l, end, took := runLoad(t, 4, LoadProfile{Ramp: RAMP_LINEAR, RampUp: 200 * time.Millisecond}, 0)
+	if end.Status != SUCCESS {
+		t.Error("ramped run failed", end.Status)
+	}
+	if took < 150*time.Millisecond {
+		t.Error("threads were not ramped up", took)
+	}
+	res := l.LastRunResult
+	if res.Runs != 4 || res.Results["sleep"].Stats.Complete != 4 {
+		t.Error("expected each thread to run once", res.Runs)
+	}
+	if res.Peak < 1 || res.Peak > 4 || len(res.Concurrency) == 0 {
+		t.Error("concurrency not recorded", res.Peak, res.Concurrency)
+	}
+}
+
+func Test_LoadDuration(t *testing.T) {
+	lp := LoadProfile{Duration: 200 * time.Millisecond, SampleEvery: 20 * time.Millisecond}
+	l, end, took := runLoad(t, 2, lp, 0)
+	if end.Status != SUCCESS {
+		t.Error("duration run failed", end.Status)
+	}
+	if took < 200*time.Millisecond {
+		t.Error("run ended before the duration", took)
+	}
+
+	res := l.LastRunResult
+	if res.Runs <= 2 {
+		t.Error("threads did not keep running", res.Runs)
+	}
+	if res.Peak != 2 {
+		t.Error("expected both threads running at once", res.Peak)
+	}
+	if len(res.Concurrency) < 5 {
+		t.Error("expected a sample every 20ms", len(res.Concurrency))
+	}
+	last := res.Concurrency[len(res.Concurrency)-1]
+	if last.Running != 0 {
+		t.Error("flows still running at the end", last)
+	}
+}
+
+func Test_LoadRate(t *testing.T) {
+	l, end, took := runLoad(t, 3, LoadProfile{Ramp: RAMP_RATE, Rate: 20}, 0)
+	if end.Status != SUCCESS || l.LastRunResult.Runs != 3 {
+		t.Error("expected 3 runs", end.Status, l.LastRunResult.Runs)
+	}
+	if took < 100*time.Millisecond {
+		t.Error("runs did not arrive at the rate", took)
+	}
+
+	l, _, _ = runLoad(t, 3, LoadProfile{Ramp: RAMP_RATE, Rate: 20, Duration: 300 * time.Millisecond}, 0)
+	if runs := l.LastRunResult.Runs; runs < 3 || runs > 8 {
+		t.Error("expected about 6 runs in 300ms at 20 a second got", runs)
+	}
+}
+
+func Test_LoadStop(t *testing.T) {
+	l, end, took := runLoad(t, 2, LoadProfile{Duration: 10 * time.Second}, 100*time.Millisecond)
+	if took > 2*time.Second {
+		t.Error("stopping did not end the load run", took)
+	}
+	if end.Status != CANCELLED || !l.LastRunResult.Cancelled {
+		t.Error("expected the run to be cancelled", end.Status)
+	}
+}
@@ -0,0 +1,251 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/golang/glog"
+)
+
+// how a loop node decides whether to go round again - only one of While, Count or UntilSuccess should be set
+type LoopSpec struct {
+	While        string // loop while this expression (see Condition) is true for the params coming into the loop
+	Count        int    // loop exactly this many times
+	UntilSuccess bool   // loop until the body completes with SUCCESS
+	Max          int    // the most iterations for While or UntilSuccess - the loop fails if it is reached, zero means no limit for While (UntilSuccess must have one)
+}
+
+// A loop node re-executes its body until its spec says stop. Each time round it completes with
+// the LOOP status and fires the body with the iteration number (starting at 1) in Params.Iteration
+// and the <loop-id>.iteration prop. The last node in the body must have the loop node as its next.
+// When the loop finishes it completes with SUCCESS (or FAIL if the body never succeeded or Max was
+// reached) and publishes the number of iterations as the <loop-id>.iterations output.
+type LoopNode struct {
+	name   string
+	id     string
+	flow   *Workflow
+	C      chan *Params
+	spec   LoopSpec
+	while  *Condition
+	Body   TriggeredTaskNode
+	Outs   map[int][]TriggeredTaskNode // nodes to fire by the status of the loop when it finishes
+	merges []*MergeNode
+}
+
+func (tn *LoopNode) SetMergeTrigger(m *MergeNode) {
+	tn.merges = append(tn.merges, m)
+}
+
+func (tn *LoopNode) SetWorkFlow(f *Workflow) {
+	tn.flow = f
+}
+
+func (tn *LoopNode) WorkFlow() *Workflow {
+	return tn.flow
+}
+
+func (tn *LoopNode) DoneChan() chan *Params {
+	return tn.C
+}
+
+func (tn *LoopNode) FireDoneChan(p *Params) {
+	tn.C <- p
+}
+
+func (tn *LoopNode) Name() string {
+	return tn.name
+}
+
+func (tn *LoopNode) Id() string {
+	return tn.id
+}
+
+func (tn *LoopNode) Type() string {
+	return "loop"
+}
+
+func (tn *LoopNode) SetStream(cs *io.PipeWriter) {}
+
+func (tn *LoopNode) Spec() LoopSpec {
+	return tn.spec
+}
+
+// the first node of the body - fired on each iteration
+func (tn *LoopNode) SetBody(t TriggeredTaskNode) error {
+	if tn.flow == nil {
+		return errors.New("can't add next nodes if current flow not set")
+	}
+
+	if t.WorkFlow() != tn.flow {
+		panic("next nodes have to be in the same workflow")
+	}
+
+	tn.flow.registerNode(t)
+	tn.Body = t
+	return nil
+}
+
+// add a node to fire when the loop finishes with the given status
+func (tn *LoopNode) AddNext(forStatus int, t TriggeredTaskNode) error {
+	if forStatus == LOOP {
+		return tn.SetBody(t)
+	}
+
+	if tn.flow == nil {
+		return errors.New("can't add next nodes if current flow not set")
+	}
+
+	if t.WorkFlow() != tn.flow {
+		panic("next nodes have to be in the same workflow")
+	}
+
+	if tn.Outs == nil {
+		tn.Outs = make(map[int][]TriggeredTaskNode)
+	}
+
+	tn.flow.registerNode(t)
+	tn.Outs[forStatus] = append(tn.Outs[forStatus], t)
+
+	return nil
+}
+
+func (tn *LoopNode) Edges() []Edge {
+	edges := make([]Edge, 0, 1)
+	if tn.Body != nil {
+		edges = append(edges, Edge{Name: fmt.Sprintf("%v", LOOP), Status: LOOP, From: tn.Id(), To: tn.Body.Id()})
+	}
+
+	for val, x := range tn.Outs {
+		for _, xi := range x {
+			edges = append(edges, Edge{Name: fmt.Sprintf("%v", val), Status: val, From: tn.Id(), To: xi.Id()})
+		}
+	}
+
+	return edges
+}
+
+// the props that hold the loop state while the body runs
+func (tn *LoopNode) iterationKey() string {
+	return OutputKey(tn.Id(), "iteration")
+}
+
+func (tn *LoopNode) outerKey() string {
+	return OutputKey(tn.Id(), "outer-iteration")
+}
+
+// again decides if the loop goes round again given how many iterations are done and the params
+// that came into the loop, if not it returns the status to finish with
+func (tn *LoopNode) again(done int, inPar *Params, returning bool) (bool, int) {
+	switch {
+	case tn.spec.Count > 0:
+		return done < tn.spec.Count, SUCCESS
+
+	case tn.spec.UntilSuccess:
+		if returning && inPar.Status == SUCCESS {
+			return false, SUCCESS
+		}
+		if tn.spec.Max > 0 && done >= tn.spec.Max {
+			return false, FAIL
+		}
+		return true, 0
+
+	case tn.while != nil:
+		if !tn.while.Eval(inPar) {
+			return false, SUCCESS
+		}
+		if tn.spec.Max > 0 && done >= tn.spec.Max {
+			return false, FAIL
+		}
+		return true, 0
+	}
+
+	return false, SUCCESS
+}
+
+// called on entry to the loop and each time the body completes
+func (tn *LoopNode) Exec(inPar *Params) {
+	if inPar == nil {
+		glog.Error("ooo - you cant have null parameters")
+		return
+	}
+
+	curPar := MakeParams()
+	curPar.Copy(inPar)
+	curPar.TaskName = tn.Name()
+	curPar.TaskId = tn.Id()
+	curPar.TaskType = tn.Type()
+	curPar.Complete = true
+
+	// wait for the stepper (or the debugger) on the way in and each time round - unless the flow
+	// was stopped in the meantime
+	if tn.flow.Cancelled() || !tn.flow.waitStep(curPar) {
+		curPar.Status = CANCELLED
+		select {
+		case tn.flow.End.DoneChan() <- curPar:
+		default:
+		}
+		return
+	}
+
+	// if we have the iteration prop we are coming back round from the body
+	done := 0
+	it, returning := inPar.Props[tn.iterationKey()]
+	if returning {
+		done, _ = strconv.Atoi(it)
+	} else {
+		curPar.Props[tn.outerKey()] = strconv.Itoa(inPar.Iteration)
+	}
+
+	again, status := tn.again(done, inPar, returning)
+
+	if again {
+		curPar.Status = LOOP
+		curPar.Iteration = done + 1
+		curPar.Props[tn.iterationKey()] = strconv.Itoa(curPar.Iteration)
+
+		glog.Info("loop ", tn.Id(), " iteration ", curPar.Iteration)
+		tn.flow.send(curPar)
+
+		if tn.Body == nil {
+			glog.Warning("problem - loop with no body - this workflow may not of ended properly")
+			tn.flow.End.FireDoneChan(curPar)
+			return
+		}
+		go tn.Body.Exec(curPar)
+		return
+	}
+
+	// finished - put back any outer loop iteration and tidy up our state
+	curPar.Status = status
+	curPar.Iteration, _ = strconv.Atoi(curPar.Props[tn.outerKey()])
+	delete(curPar.Props, tn.iterationKey())
+	delete(curPar.Props, tn.outerKey())
+	curPar.SetOutput("iterations", strconv.Itoa(done))
+	curPar.publishOutputs(tn.Id())
+
+	glog.Info("loop ", tn.Id(), " finished after ", done, " iterations with status ", status)
+	tn.flow.send(curPar)
+
+	if tn.C != nil {
+		if len(tn.C) > 0 {
+			<-tn.C
+		}
+		tn.C <- curPar
+	}
+
+	for _, m := range tn.merges {
+		m.arrive(tn.Id(), curPar)
+	}
+
+	next := tn.Outs[status]
+	for _, n := range next {
+		go n.Exec(curPar)
+	}
+
+	if len(next) == 0 && len(tn.merges) == 0 && TriggeredTaskNode(tn) != tn.flow.End {
+		glog.Warning("problem - dead end loop - this workflow may not of ended properly")
+		tn.flow.End.FireDoneChan(curPar)
+	}
+}
@@ -0,0 +1,93 @@
+package flow
+
+import (
+	"testing"
+)
+
+// a loop with the body task and an end node after it - returns the statuses sent to the flow
+func runLoop(t *testing.T, spec LoopSpec, body Task) ([]*Params, *Params) {
+	w := MakeWorkflow()
+	w.Name = "loop"
+	s := w.MakeTaskNode("s", nopTask{})
+	l, err := w.MakeLoopNode("l", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := w.MakeTaskNode("b", body)
+	e := w.MakeTaskNode("e", nopTask{})
+	s.AddNext(SUCCESS, l)
+	l.AddNext(LOOP, b)
+	b.AddNext(SUCCESS, l)
+	b.AddNext(FAIL, l)
+	l.AddNext(SUCCESS, e)
+	l.AddNext(FAIL, e)
+	w.SetStart(s)
+	w.SetEnd(e)
+
+	return runTestFlow(t, w, e)
+}
+
+func Test_LoopCount(t *testing.T) {
+	statuses, end := runLoop(t, LoopSpec{Count: 3}, nopTask{})
+
+	its := []int{}
+	for _, p := range statuses {
+		if p.TaskId == "b" {
+			its = append(its, p.Iteration)
+		}
+	}
+	if len(its) != 3 || its[0] != 1 || its[2] != 3 {
+		t.Error("body should run for iterations 1 to 3", its)
+	}
+
+	if end.Iteration != 0 || end.Props["l.iterations"] != "3" || end.Props["l.iteration"] != "" {
+		t.Error("loop state leaked out of the loop", end.Iteration, end.Props)
+	}
+}
+
+func Test_LoopUntilSuccess(t *testing.T) {
+	_, end := runLoop(t, LoopSpec{UntilSuccess: true, Max: 5}, &flakyTask{n: 3})
+	if end.Props["l.iterations"] != "3" {
+		t.Error("should stop after the first success", end.Props)
+	}
+
+	statuses, _ := runLoop(t, LoopSpec{UntilSuccess: true, Max: 2}, &flakyTask{n: 3})
+	last := statuses[len(statuses)-2]
+	if last.TaskId != "l" || last.Status != FAIL {
+		t.Error("loop should fail when max is reached", last.TaskId, last.Status)
+	}
+}
+
+func Test_LoopWhile(t *testing.T) {
+	if _, err := MakeWorkflow().MakeLoopNode("l", LoopSpec{While: "l.iteration <"}); err == nil {
+		t.Error("bad expression should not make a loop")
+	}
+
+	_, end := runLoop(t, LoopSpec{While: `l.iteration == "" || l.iteration < 4`}, nopTask{})
+	if end.Props["l.iterations"] != "4" {
+		t.Error("wrong number of iterations", end.Props)
+	}
+}
+
+func Test_LoopStats(t *testing.T) {
+	f := NewFlowLaunchResult(2)
+	f.AddTask("b")
+	for it := 1; it <= 2; it++ {
+		for th := 0; th < 2; th++ {
+			p := MakeParams()
+			p.TaskId = "b"
+			p.ThreadId = th
+			p.Iteration = it
+			p.Complete = true
+			f.AddStatusOrResult(p)
+			if it == 2 && th == 0 && f.Results["b"].Stats.PercentComplete != 50 {
+				t.Error("progress should be for the current iteration", f.Results["b"].Stats.PercentComplete)
+			}
+		}
+	}
+
+	s := f.Results["b"].Stats
+	if s.Iteration != 2 || len(s.Iterations) != 2 || s.Iterations[0].Complete != 2 || s.PercentComplete != 100 {
+		t.Error("bad iteration stats", s.Iteration, s.Iterations, s.PercentComplete)
+	}
+}
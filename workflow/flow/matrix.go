@@ -0,0 +1,81 @@
+package flow
+
+import (
+	"errors"
+	"strings"
+)
+
+// one dimension of a matrix - each value is set as the Name prop of the threads that use it
+type MatrixAxis struct {
+	Name   string
+	Values []string
+}
+
+// A Matrix expands a launcher into one thread for every combination of the values of its axes.
+// The axes are kept in order so the cells (and their thread ids) are the same on every run.
+type Matrix []MatrixAxis
+
+// one combination of matrix values - the thread with ThreadId runs with Props added to its props
+type MatrixCell struct {
+	ThreadId int
+	Label    string // e.g. go_version=1.9,os=linux
+	Props    Props
+}
+
+// check the matrix can be expanded
+func (m Matrix) Validate() error {
+	if len(m) == 0 {
+		return errors.New("matrix has no axes")
+	}
+	seen := map[string]bool{}
+	for _, a := range m {
+		if a.Name == "" {
+			return errors.New("matrix axis has no name")
+		}
+		if seen[a.Name] {
+			return errors.New("matrix axis " + a.Name + " is repeated")
+		}
+		seen[a.Name] = true
+		if len(a.Values) == 0 {
+			return errors.New("matrix axis " + a.Name + " has no values")
+		}
+	}
+	return nil
+}
+
+// every combination of values - the last axis varies fastest
+func (m Matrix) Cells() []MatrixCell {
+	if len(m) == 0 {
+		return nil
+	}
+
+	combos := []Props{{}}
+	for _, a := range m {
+		next := make([]Props, 0, len(combos)*len(a.Values))
+		for _, c := range combos {
+			for _, v := range a.Values {
+				p := Props{}
+				for k, cv := range c {
+					p[k] = cv
+				}
+				p[a.Name] = v
+				next = append(next, p)
+			}
+		}
+		combos = next
+	}
+
+	cells := make([]MatrixCell, len(combos))
+	for i, p := range combos {
+		parts := make([]string, len(m))
+		for j, a := range m {
+			parts[j] = a.Name + "=" + p[a.Name]
+		}
+		cells[i] = MatrixCell{
+			ThreadId: i,
+			Label:    strings.Join(parts, ","),
+			Props:    p,
+		}
+	}
+	return cells
+}
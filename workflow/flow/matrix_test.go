@@ -0,0 +1,86 @@
+package flow
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_MatrixCells(t *testing.T) {
+	m := Matrix{
+		{Name: "version", Values: []string{"1.9", "1.10"}},
+		{Name: "os", Values: []string{"linux", "darwin", "windows"}},
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cells := m.Cells()
+	if len(cells) != 6 {
+		t.Fatal("expected 6 cells got", len(cells))
+	}
+	if cells[0].Label != "version=1.9,os=linux" || cells[5].Label != "version=1.10,os=windows" {
+		t.Error("cells in the wrong order", cells[0].Label, cells[5].Label)
+	}
+	for i, c := range cells {
+		if c.ThreadId != i || len(c.Props) != 2 {
+			t.Error("bad cell", c)
+		}
+	}
+
+	bad := []Matrix{
+		{},
+		{{Name: "", Values: []string{"a"}}},
+		{{Name: "a", Values: nil}},
+		{{Name: "a", Values: []string{"x"}}, {Name: "a", Values: []string{"y"}}},
+	}
+	for _, b := range bad {
+		if b.Validate() == nil {
+			t.Error("expected matrix to be invalid", b)
+		}
+	}
+}
+
+func Test_MatrixLauncher(t *testing.T) {
+	cf := &childFlow{dir: t.TempDir()}
+	cf.Init("matrix")
+	l := MakeFlowLauncher(cf, 1, nil, nil)
+
+	err := l.SetMatrix(Matrix{
+		{Name: "version", Values: []string{"1.9", "1.10"}},
+		{Name: "db", Values: []string{"pg", "mysql"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Threads != 4 {
+		t.Fatal("expected a thread per cell got", l.Threads)
+	}
+
+	ec := make(chan *Params, 1)
+	go l.Start(time.Millisecond, ec)
+
+	select {
+	case end := <-ec:
+		if end.Status != SUCCESS {
+			t.Error("matrix run failed", end.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("matrix run did not end")
+	}
+
+	res := l.LastRunResult
+	if len(res.Cells) != 4 {
+		t.Fatal("expected results for each cell got", len(res.Cells))
+	}
+	for _, c := range res.Cells {
+		if !c.Completed || c.Status != SUCCESS || len(c.Failed) != 0 {
+			t.Error("bad cell result", c.Label, c.Status, c.Failed)
+		}
+		if c.Outputs["build.version"] != c.Props["version"] {
+			t.Error("cell", c.Label, "did not run with its props", c.Outputs)
+		}
+	}
+	if res.Results["build"].Stats.Complete != 4 {
+		t.Error("expected every thread to complete", res.Results["build"].Stats.Complete)
+	}
+}
@@ -4,22 +4,55 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+type JoinMode int
+
+const (
+	JOIN_ALL JoinMode = iota // wait for all of the triggers
+	JOIN_ANY                 // the first trigger wins - the others are cancelled
+	JOIN_N                   // wait for N of the triggers - the others are cancelled
 )
 
-// A merge node is a type of task that simply waits for all of its registered tasks to complete (trigger)
+// how a merge node decides it is complete
+// with RequireSuccess (all-succeeded) only successful triggers count and the merge fails as soon as
+// enough have failed that it can never succeed, without it (all-completed) every trigger counts and
+// the merge completes with the status they all share - or FAIL if they differ
+type Join struct {
+	Mode           JoinMode
+	N              int // for JOIN_N
+	RequireSuccess bool
+}
+
+// A merge node is a type of task that waits for its registered tasks to complete (trigger)
+// it re-arms each time it fires so it can be used inside a loop
 type MergeNode struct {
 	name     string
 	id       string
 	flow     *Workflow
 	C        chan *Params
 	Next     *TaskNode
-	Triggers map[string]TriggeredTaskNode
-	Group    sync.WaitGroup
-	first    bool
+	Triggers map[string]TriggeredTaskNode // by node id - the same key as the round they arrive in
+	Outs     map[int][]TriggeredTaskNode  // nodes to fire by the status of the merge - e.g. TIMEOUT
+	join     Join
+	timeout  time.Duration // how long to wait for the triggers after the first one fires
+	merges   []*MergeNode  // merge nodes this node triggers
+
+	lock    sync.Mutex
+	round   map[string]*Params // the params from each trigger in this round by trigger id
+	fired   bool               // this round has already fired - late triggers are swallowed
+	queued  []*Params          // triggers that fired again before this round finished
+	queueBy []string
+	timer   *time.Timer
 }
 
-func (tn *MergeNode) SetMergeTrigger() {
+func (tn *MergeNode) SetMergeTrigger(m *MergeNode) {
+	tn.merges = append(tn.merges, m)
 }
 
 func (tn *MergeNode) SetWorkFlow(f *Workflow) {
@@ -52,6 +85,41 @@ func (tn *MergeNode) Type() string {
 
 func (tn *MergeNode) SetStream(cs *io.PipeWriter) {}
 
+// set how the merge decides it is complete - the default is to wait for all triggers
+func (tn *MergeNode) SetJoin(j Join) {
+	tn.join = j
+}
+
+func (tn *MergeNode) Join() Join {
+	return tn.join
+}
+
+// set how long the merge waits for its triggers once the first one has fired
+// if they dont arrive in time the merge completes with the TIMEOUT status
+func (tn *MergeNode) SetTimeout(d time.Duration) {
+	tn.timeout = d
+}
+
+// add a node to fire when the merge completes with the given status
+func (tn *MergeNode) AddNext(forStatus int, t TriggeredTaskNode) error {
+	if tn.flow == nil {
+		return errors.New("can't add next nodes if current flow not set")
+	}
+
+	if t.WorkFlow() != tn.flow {
+		panic("next nodes have to be in the same workflow")
+	}
+
+	if tn.Outs == nil {
+		tn.Outs = make(map[int][]TriggeredTaskNode)
+	}
+
+	tn.flow.registerNode(t)
+	tn.Outs[forStatus] = append(tn.Outs[forStatus], t)
+
+	return nil
+}
+
 func (tn *MergeNode) Edges() []Edge {
 	edges := make([]Edge, 0, 1)
 	for _, x := range tn.Triggers { // triggers are inbound
@@ -59,7 +127,13 @@ func (tn *MergeNode) Edges() []Edge {
 	}
 
 	if tn.Next != nil {
-		edges = append(edges, Edge{Name: fmt.Sprintf("%v", 0), From: tn.Id(), To: tn.Next.Id()})
+		edges = append(edges, Edge{Name: fmt.Sprintf("%v", SUCCESS), Status: SUCCESS, From: tn.Id(), To: tn.Next.Id()})
+	}
+
+	for val, x := range tn.Outs {
+		for _, xi := range x {
+			edges = append(edges, Edge{Name: fmt.Sprintf("%v", val), Status: val, From: tn.Id(), To: xi.Id()})
+		}
 	}
 
 	return edges
@@ -68,6 +142,7 @@ func (tn *MergeNode) Edges() []Edge {
 // mergenodes only lissten for triggers
 func (tn *MergeNode) Exec(p *Params) {}
 
+// the node to fire when the merge succeeds
 func (tn *MergeNode) SetNext(t *TaskNode) {
 	// make sure we have a cpy of this in the parent map
 	tn.flow.registerNode(t)
@@ -84,53 +159,270 @@ func (tn *MergeNode) AddTrigger(t TriggeredTaskNode) error {
 		tn.Triggers = make(map[string]TriggeredTaskNode)
 	}
 
-	// make sure this task has a chanel
-	if t.DoneChan() == nil {
-		panic("triggers must have a done chanel")
-	}
-
 	if t.WorkFlow() != tn.flow {
 		panic("triggers must be in the same workflow as the merge node they trigger")
 	}
 
-	// tell the tasknode it does trigger something
-	t.SetMergeTrigger()
-
-	_, ok := tn.Triggers[t.Name()]
+	_, ok := tn.Triggers[t.Id()]
 	if !ok {
-		tn.Triggers[t.Name()] = t
-
-		var par *Params // last params wins
-		tn.Group.Add(1)
-		go func() {
-			par = <-t.DoneChan()
-			tn.Group.Done()
-		}()
-
-		if tn.first {
-			go func() {
-				tn.Group.Wait()
-				// tell the flow status channel we have completed
-				curPar := &Params{}
-				curPar.Copy(par)
-				curPar.TaskName = tn.Name()
-				curPar.TaskId = tn.Id()
-
-				curPar.Complete = true
-				tn.flow.C <- curPar
-				fmt.Println("Trigger fired")
-
-				// and trigger our end channel
-				tn.C <- curPar
-
-				// and if there is another task fire that off
-				if tn.Next != nil {
-					fmt.Println("Merge node calling single next node")
-					go tn.Next.Exec(curPar)
-				}
-			}()
-		}
-		tn.first = false
+		tn.Triggers[t.Id()] = t
+
+		// tell the node to let us know when it completes
+		t.SetMergeTrigger(tn)
 	}
 	return nil
 }
+
+// how many triggers have to count for the merge to fire
+func (tn *MergeNode) needed() int {
+	switch tn.join.Mode {
+	case JOIN_ANY:
+		return 1
+	case JOIN_N:
+		if tn.join.N > 0 && tn.join.N < len(tn.Triggers) {
+			return tn.join.N
+		}
+	}
+	return len(tn.Triggers)
+}
+
+// called by a trigger node when it has completed
+func (tn *MergeNode) arrive(triggerId string, p *Params) {
+	tn.lock.Lock()
+
+	if tn.round == nil {
+		tn.round = map[string]*Params{}
+	}
+
+	if _, ok := tn.round[triggerId]; ok {
+		if !tn.fired {
+			// this trigger has gone round again before the others caught up - keep it for the next round
+			tn.queued = append(tn.queued, p)
+			tn.queueBy = append(tn.queueBy, triggerId)
+			tn.lock.Unlock()
+			return
+		}
+		// the stragglers from the last round are not coming
+		tn.rearm()
+	}
+
+	tn.round[triggerId] = p
+
+	if len(tn.round) == 1 && tn.timeout > 0 && !tn.fired {
+		tn.timer = time.AfterFunc(tn.timeout, tn.fireTimeout)
+	}
+
+	status, fire := tn.decide()
+	if fire {
+		tn.fired = true
+		if tn.timer != nil {
+			tn.timer.Stop()
+		}
+	}
+
+	var curPar *Params
+	var pending []TriggeredTaskNode
+	if fire {
+		curPar = tn.mergeParams()
+		curPar.Status = status
+		pending = tn.pending()
+	}
+
+	// everyone has turned up so start again
+	replay := tn.roundComplete()
+
+	tn.lock.Unlock()
+
+	if fire {
+		// the others are not needed any more
+		for _, n := range pending {
+			if c, ok := n.(interface {
+				Cancel()
+			}); ok {
+				c.Cancel()
+			}
+		}
+		tn.fire(curPar)
+	}
+
+	for i, p := range replay.pars {
+		tn.arrive(replay.by[i], p)
+	}
+}
+
+type replay struct {
+	by   []string
+	pars []*Params
+}
+
+// if all triggers have arrived then re-arm and hand back any queued arrivals - must hold the lock
+func (tn *MergeNode) roundComplete() replay {
+	if len(tn.round) < len(tn.Triggers) {
+		return replay{}
+	}
+	r := replay{by: tn.queueBy, pars: tn.queued}
+	tn.queued = nil
+	tn.queueBy = nil
+	tn.rearm()
+	return r
+}
+
+// must hold the lock
+func (tn *MergeNode) rearm() {
+	tn.round = map[string]*Params{}
+	tn.fired = false
+	if tn.timer != nil {
+		tn.timer.Stop()
+		tn.timer = nil
+	}
+}
+
+// the triggers that have not arrived yet this round - must hold the lock
+func (tn *MergeNode) pending() []TriggeredTaskNode {
+	pend := []TriggeredTaskNode{}
+	for _, t := range tn.Triggers {
+		if _, ok := tn.round[t.Id()]; !ok {
+			pend = append(pend, t)
+		}
+	}
+	return pend
+}
+
+// has this round got enough to fire and with what status - must hold the lock
+func (tn *MergeNode) decide() (int, bool) {
+	if tn.fired {
+		return 0, false
+	}
+
+	needed := tn.needed()
+	remaining := len(tn.Triggers) - len(tn.round)
+
+	if tn.join.RequireSuccess {
+		succeeded := 0
+		for _, p := range tn.round {
+			if p.Status == SUCCESS {
+				succeeded++
+			}
+		}
+		if succeeded >= needed {
+			return SUCCESS, true
+		}
+		// can never get enough
+		if succeeded+remaining < needed {
+			return FAIL, true
+		}
+		return 0, false
+	}
+
+	if len(tn.round) < needed {
+		return 0, false
+	}
+
+	// they all agree or it failed
+	status := -1
+	for _, p := range tn.round {
+		if status == -1 {
+			status = p.Status
+		} else if status != p.Status {
+			return FAIL, true
+		}
+	}
+	return status, true
+}
+
+// not all triggers arrived in time - complete with the TIMEOUT status
+func (tn *MergeNode) fireTimeout() {
+	tn.lock.Lock()
+	if tn.fired || len(tn.round) == 0 {
+		tn.lock.Unlock()
+		return
+	}
+
+	glog.Warning("merge node timed out ", tn.Id(), " after ", tn.timeout)
+
+	tn.fired = true
+	curPar := tn.mergeParams()
+	curPar.Status = TIMEOUT
+	curPar.Response = fmt.Sprintf("merge timed out after %v", tn.timeout)
+	pending := tn.pending()
+	tn.lock.Unlock()
+
+	for _, n := range pending {
+		if c, ok := n.(interface {
+			Cancel()
+		}); ok {
+			c.Cancel()
+		}
+	}
+
+	tn.fire(curPar)
+}
+
+// the merge has completed - tell the flow and fire the next nodes for the status
+func (tn *MergeNode) fire(curPar *Params) {
+	curPar.TaskName = tn.Name()
+	curPar.TaskId = tn.Id()
+	curPar.Complete = true
+
+	// wait for the stepper (or the debugger) - unless the flow was stopped in the meantime
+	if tn.flow.Cancelled() || !tn.flow.waitStep(curPar) {
+		return
+	}
+
+	// tell the flow status channel we have completed
+	tn.flow.send(curPar)
+	glog.Info("merge fired ", tn.Id(), " with status ", curPar.Status)
+
+	// and trigger our end channel
+	if len(tn.C) > 0 {
+		<-tn.C
+	}
+	tn.C <- curPar
+
+	for _, m := range tn.merges {
+		m.arrive(tn.Id(), curPar)
+	}
+
+	next := tn.Outs[curPar.Status]
+	if curPar.Status == SUCCESS && tn.Next != nil {
+		next = append([]TriggeredTaskNode{tn.Next}, next...)
+	}
+
+	for _, n := range next {
+		go n.Exec(curPar)
+	}
+
+	// nothing to handle this status so the thread ends here
+	if len(next) == 0 && len(tn.merges) == 0 && TriggeredTaskNode(tn) != tn.flow.End {
+		glog.Warning("problem - dead end merge - this workflow may not of ended properly")
+		tn.flow.End.FireDoneChan(curPar)
+	}
+}
+
+// combine the params from all the triggers - props (including all the upstream outputs) are
+// added in trigger id order so the same key set by more than one branch always resolves the same way
+// must hold the lock
+func (tn *MergeNode) mergeParams() *Params {
+	ids := make([]string, 0, len(tn.round))
+	for id := range tn.round {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	curPar := &Params{}
+	for i, id := range ids {
+		par := tn.round[id]
+		if i == 0 {
+			curPar.Copy(par)
+			continue
+		}
+		for k, v := range par.Props {
+			curPar.Props[k] = v
+		}
+	}
+
+	if curPar.Props == nil {
+		curPar.Props = Props{}
+	}
+	return curPar
+}
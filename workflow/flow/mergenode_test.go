@@ -0,0 +1,142 @@
+package flow
+
+import (
+	"testing"
+	"time"
+)
+
+func mergeFixture(j Join) (*Workflow, *MergeNode, []*TaskNode) {
+	w := MakeWorkflow()
+	a := w.MakeTaskNode("a", nopTask{})
+	b := w.MakeTaskNode("b", nopTask{})
+	c := w.MakeTaskNode("c", nopTask{})
+	m := w.MakeMergeNode("m")
+	m.SetJoin(j)
+	m.AddTrigger(a)
+	m.AddTrigger(b)
+	m.AddTrigger(c)
+	w.SetEnd(m)
+	swallow(w)
+
+	return w, m, []*TaskNode{a, b, c}
+}
+
+// swallow the status updates and give the nodes every step they wait for
+func swallow(w *Workflow) {
+	go func() {
+		for range w.C {
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case w.Stepper <- 1:
+			case <-w.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+func arrived(id string, status int) *Params {
+	p := MakeParams()
+	p.TaskId = id
+	p.Status = status
+	p.SetOutput("k", id)
+	p.publishOutputs(id)
+	return p
+}
+
+func expectMerge(t *testing.T, m *MergeNode, status int) *Params {
+	select {
+	case p := <-m.C:
+		if p.Status != status {
+			t.Error("merge fired with wrong status", p.Status, "expected", status)
+		}
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("merge did not fire")
+	}
+	return nil
+}
+
+func expectNoMerge(t *testing.T, m *MergeNode) {
+	select {
+	case p := <-m.C:
+		t.Error("merge fired early", p)
+	default:
+	}
+}
+
+func Test_MergeAllRearms(t *testing.T) {
+	_, m, _ := mergeFixture(Join{})
+
+	for round := 0; round < 2; round++ {
+		m.arrive("a", arrived("a", SUCCESS))
+		m.arrive("b", arrived("b", SUCCESS))
+		expectNoMerge(t, m)
+		m.arrive("c", arrived("c", SUCCESS))
+		p := expectMerge(t, m, SUCCESS)
+
+		// outputs from all the branches
+		for _, id := range []string{"a", "b", "c"} {
+			if p.Props[OutputKey(id, "k")] != id {
+				t.Error("missing output from", id)
+			}
+		}
+	}
+
+	// the same trigger again before the others is kept for the next round
+	m.arrive("a", arrived("a", SUCCESS))
+	m.arrive("a", arrived("a", FAIL))
+	m.arrive("b", arrived("b", SUCCESS))
+	m.arrive("c", arrived("c", SUCCESS))
+	expectMerge(t, m, SUCCESS)
+	m.arrive("b", arrived("b", SUCCESS))
+	m.arrive("c", arrived("c", SUCCESS))
+	expectMerge(t, m, FAIL)
+}
+
+func Test_MergeTriggerIds(t *testing.T) {
+	w := MakeWorkflow()
+	a := w.MakeTaskNode("Unit Tests", nopTask{})
+	b := w.MakeTaskNode("Lint", nopTask{})
+	m := w.MakeMergeNode("m")
+	m.AddTrigger(a)
+	m.AddTrigger(b)
+	m.AddTrigger(b)
+	w.SetEnd(m)
+	swallow(w)
+
+	if _, ok := m.Triggers["unit-tests"]; !ok || len(m.Triggers) != 2 {
+		t.Error("triggers should be kept by id", m.Triggers)
+	}
+
+	// the triggers arrive with their ids
+	m.arrive("unit-tests", arrived("unit-tests", SUCCESS))
+	expectNoMerge(t, m)
+	m.arrive("lint", arrived("lint", SUCCESS))
+	expectMerge(t, m, SUCCESS)
+}
+
+func Test_MergeAnyAndN(t *testing.T) {
+	_, m, _ := mergeFixture(Join{Mode: JOIN_ANY})
+	m.arrive("b", arrived("b", SUCCESS))
+	expectMerge(t, m, SUCCESS)
+	m.arrive("a", arrived("a", FAIL))
+	m.arrive("c", arrived("c", FAIL))
+	expectNoMerge(t, m)
+
+	_, m, _ = mergeFixture(Join{Mode: JOIN_N, N: 2, RequireSuccess: true})
+	m.arrive("a", arrived("a", FAIL))
+	expectNoMerge(t, m)
+	m.arrive("b", arrived("b", FAIL))
+	expectMerge(t, m, FAIL)
+}
+
+func Test_MergeTimeout(t *testing.T) {
+	_, m, _ := mergeFixture(Join{})
+	m.SetTimeout(10 * time.Millisecond)
+	m.arrive("a", arrived("a", SUCCESS))
+	expectMerge(t, m, TIMEOUT)
+}
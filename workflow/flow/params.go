@@ -1,7 +1,16 @@
 package flow
 
+import (
+	"time"
+)
+
 type Props map[string]string
 
+// the key that a tasks output is published to downstream nodes as
+func OutputKey(taskId, key string) string {
+	return taskId + "." + key
+}
+
 type Params struct {
 	FlowName   string // these three make up a unique ID for the task
 	ThreadId   int
@@ -13,7 +22,14 @@ type Params struct {
 	ExitStatus int
 	Response   string
 	Props      Props
+	Outputs    Props // named outputs set by the task - published to later tasks as <task-id>.<key> props
 	Raw        []byte
+	Attempt    int         // which attempt this is if the task has a retry policy
+	Retrying   bool        // set on the result of an attempt that is going to be retried
+	Iteration  int         // the iteration of the innermost loop this task is in - zero if it is not in one
+	Started    time.Time   // when the node started executing - zero for nodes that are not timed
+	Ended      time.Time   // when it finished - set on the completed params
+	subRun     *SubFlowRun // the child run started by a sub flow node - linked into the results
 }
 
 func MakeParams() *Params {
@@ -32,5 +48,26 @@ func (p *Params) Copy(ip *Params) {
 
 	// and the other info stuff
 	p.TaskType = ip.TaskType
-	p.Props = ip.Props
+	p.Iteration = ip.Iteration
+
+	// each node gets its own props so tasks in parallel branches cant trample each other
+	p.Props = Props{}
+	for k, v := range ip.Props {
+		p.Props[k] = v
+	}
+}
+
+// publish a named output of the task
+func (p *Params) SetOutput(key, value string) {
+	if p.Outputs == nil {
+		p.Outputs = Props{}
+	}
+	p.Outputs[key] = value
+}
+
+// add the outputs to the props namespaced by the task id - so later tasks can use them
+func (p *Params) publishOutputs(taskId string) {
+	for k, v := range p.Outputs {
+		p.Props[OutputKey(taskId, k)] = v
+	}
 }
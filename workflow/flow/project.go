@@ -2,6 +2,7 @@ package flow
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/golang/glog"
 	"time"
 )
@@ -44,7 +45,8 @@ func (tf *TriggerFlow) inner() {
 
 		fc := make(chan *Params)
 
-		go tf.launcher.Start(time.Second, fc)
+		// anything the trigger found out (e.g. the git hash) is available to the triggered flow
+		go tf.launcher.StartWith(time.Second, tf.trigger.addedProps(res.Props), fc)
 
 		glog.Infoln("trigger:", tf.trigger.Id, "launched", tf.launcher.Id)
 
@@ -76,9 +78,10 @@ func (l *triggerFlow) GetProps() *Props {
 type Project struct {
 	Name          string
 	FlowLaunchers map[string]*FlowLauncher
-	LastResults   map[string]*FlowLaunchResult // a set of response stats by task id in our workflow for the last run
-	RunList       map[string]*RunList          // historical set of run tasks
-	Triggers      map[string]*TriggerFlow      // all the trigger flows
+	RunList       map[string]*RunList     // historical set of runs by flow id
+	Triggers      map[string]*TriggerFlow // all the trigger flows
+	MaxRuns       int                     // the most runs the run queue starts at once across all flows - zero is no limit
+	KeepRuns      int                     // the most runs kept in the history of each flow - zero keeps them all
 }
 
 func MakeProject(name string) *Project {
@@ -86,7 +89,7 @@ func MakeProject(name string) *Project {
 	return &Project{
 		Name:          name,
 		FlowLaunchers: map[string]*FlowLauncher{},
-		LastResults:   map[string]*FlowLaunchResult{},
+		RunList:       map[string]*RunList{},
 		Triggers:      map[string]*TriggerFlow{},
 	}
 }
@@ -97,11 +100,12 @@ func (p *Project) MakeTriggerLauncher(name string, flowFunc GetFlowFunc) *FlowLa
 	triggerFlow.Init(name)
 
 	launcher := &FlowLauncher{
-		Props:    triggerFlow.GetProps(),
-		Name:     triggerFlow.Name(),
-		Id:       triggerFlow.Id(),
-		flowFunc: flowFunc,
-		Threads:  1,
+		Props:     triggerFlow.GetProps(),
+		Name:      triggerFlow.Name(),
+		Id:        triggerFlow.Id(),
+		flowFunc:  flowFunc,
+		Threads:   1,
+		isTrigger: true,
 	}
 	p.AddFlow(launcher)
 
@@ -150,13 +154,66 @@ func (p *Project) AddTriggerFlow(triggerFlow *FlowLauncher) {
 	}
 }
 
-func (p *Project) ColectResults() {
-	for fid, flo := range p.FlowLaunchers {
-		if flo.LastRunResult != nil {
-			flo.LastRunResult.FlowId = fid
+// keep the runs of every flow in the store and load the runs it already has - the last run of each
+// flow becomes its last result so it is there after a restart
+func (p *Project) LoadHistory(store RunStore) error {
+	var lastErr error
+	for id, fl := range p.FlowLaunchers {
+		if fl.isTrigger {
+			continue
+		}
+		rl, err := NewRunList(id, store)
+		if err != nil {
+			glog.Error("could not load the history of ", id, " ", err)
+			lastErr = err
+		}
+		if err := rl.SetKeep(p.KeepRuns); err != nil {
+			glog.Error("could not drop the old runs of ", id, " ", err)
+			lastErr = err
+		}
+		p.RunList[id] = rl
+		fl.SetHistory(rl)
+
+		if r := rl.Latest(); r != nil && fl.lastResult() == nil {
+			fl.runsLock.Lock()
+			fl.LastRunResult = r.Result
+			fl.runsLock.Unlock()
 		}
-		p.LastResults[fid] = flo.LastRunResult
 	}
+	return lastErr
+}
+
+// the run with this id in any of the flows - nil if there is none
+func (p *Project) Run(id string) *Run {
+	for _, fl := range p.FlowLaunchers {
+		if r := fl.Run(id); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// delete the run with this id from the history of its flow
+func (p *Project) DeleteRun(id string) error {
+	r := p.Run(id)
+	if r == nil {
+		return errors.New("run not found: " + id)
+	}
+	rl, ok := p.RunList[r.FlowId]
+	if !ok {
+		return errors.New("no history for flow: " + r.FlowId)
+	}
+	return rl.Delete(id)
+}
+
+// the results of the latest run of each flow by flow id - a fresh map each call as many requests
+// can collect them at once
+func (p *Project) ColectResults() map[string]*FlowLaunchResult {
+	results := map[string]*FlowLaunchResult{}
+	for fid, flo := range p.FlowLaunchers {
+		results[fid] = flo.lastResult()
+	}
+	return results
 }
 
 // a project structure is just a list of flowstructs so we can render the project graph as json
@@ -165,12 +222,19 @@ type projectStruct struct {
 }
 
 func (p Project) ToJson() []byte {
+	return p.ToJsonOf(nil)
+}
+
+// the json of just the flows keep is true for - all of them if keep is nil
+func (p Project) ToJsonOf(keep func(flowId string) bool) []byte {
 	ps := projectStruct{
 		Flows: []FlowStruct{},
 	}
 
-	for _, f := range p.FlowLaunchers {
-		ps.Flows = append(ps.Flows, f.GetStructure())
+	for id, f := range p.FlowLaunchers {
+		if keep == nil || keep(id) {
+			ps.Flows = append(ps.Flows, f.GetStructure())
+		}
 	}
 
 	sJson, err := json.MarshalIndent(&ps, "", "  ")
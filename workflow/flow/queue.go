@@ -0,0 +1,179 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// A RunQueue holds runs until there is room for them - no more than the projects MaxRuns of the runs
+// it started are going at once, and no more than a flows own MaxRuns of that flow. Runs start in the
+// order they were queued, but a run held back by its flows limit does not hold up the runs of other
+// flows behind it. Runs started any other way (triggers, sub flows, debug) do not go through the queue.
+type RunQueue struct {
+	project *Project
+	queued  []*QueuedRun
+	running map[string]int // the runs the queue started that are still going by flow id
+	total   int
+	seq     int
+	lock    sync.Mutex
+}
+
+// a run waiting in the queue - or the copy of it handed back once it has started
+type QueuedRun struct {
+	Id        string // q-<number>
+	FlowId    string
+	User      string // who queued it
+	Position  int    // 1 is the next to start - zero once it has left the queue
+	Queued    time.Time
+	Started   time.Time
+	RunId     string // set once the run has started
+	Cancelled bool
+	Props     Props
+
+	launcher *FlowLauncher
+	delay    time.Duration
+	endChan  chan *Params
+}
+
+func NewRunQueue(p *Project) *RunQueue {
+	return &RunQueue{
+		project: p,
+		running: map[string]int{},
+	}
+}
+
+// queue a run of the flow for the user - it starts straight away if there is room, endChan gets the
+// params it ended with (or cancelled params if it is taken out of the queue before it started)
+func (q *RunQueue) Submit(flowId, user string, delay time.Duration, props Props, endChan chan *Params) (QueuedRun, error) {
+	fl, ok := q.project.FlowLaunchers[flowId]
+	if !ok {
+		return QueuedRun{}, errors.New("flow not found")
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.seq++
+	qr := &QueuedRun{
+		Id:       fmt.Sprintf("q-%d", q.seq),
+		FlowId:   flowId,
+		User:     user,
+		Queued:   time.Now(),
+		Props:    props,
+		launcher: fl,
+		delay:    delay,
+		endChan:  endChan,
+	}
+	q.queued = append(q.queued, qr)
+	q.dispatch()
+
+	glog.Info("queued ", qr.Id, " for ", flowId)
+
+	return q.snapshot(qr), nil
+}
+
+// start all the queued runs there is room for - the lock must be held
+func (q *RunQueue) dispatch() {
+	for i := 0; i < len(q.queued); {
+		if max := q.project.MaxRuns; max > 0 && q.total >= max {
+			return
+		}
+		qr := q.queued[i]
+		if max := qr.launcher.MaxRuns; max > 0 && q.running[qr.FlowId] >= max {
+			i++
+			continue
+		}
+		q.queued = append(q.queued[:i], q.queued[i+1:]...)
+		q.start(qr)
+	}
+}
+
+// start the run and free its place when it ends - the lock must be held
+func (q *RunQueue) start(qr *QueuedRun) {
+	q.running[qr.FlowId]++
+	q.total++
+
+	ec := make(chan *Params, 1)
+	r := qr.launcher.StartRunAs(qr.User, qr.delay, qr.Props, ec)
+	qr.RunId = r.Id
+	qr.Started = time.Now()
+
+	glog.Info("dequeued ", qr.Id, " as run ", r.Id)
+
+	go func() {
+		p := <-ec
+
+		q.lock.Lock()
+		q.running[qr.FlowId]--
+		q.total--
+		q.dispatch()
+		q.lock.Unlock()
+
+		if qr.endChan != nil {
+			qr.endChan <- p
+		}
+	}()
+}
+
+// the runs waiting to start - the next to start first
+func (q *RunQueue) Queued() []QueuedRun {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	runs := make([]QueuedRun, 0, len(q.queued))
+	for _, qr := range q.queued {
+		runs = append(runs, q.snapshot(qr))
+	}
+	return runs
+}
+
+// the number of runs the queue started that are still going - for the flow or all flows if flowId is empty
+func (q *RunQueue) Running(flowId string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if flowId == "" {
+		return q.total
+	}
+	return q.running[flowId]
+}
+
+// take a run out of the queue before it starts - a run that has started is stopped with its run id instead
+func (q *RunQueue) Cancel(id string) (QueuedRun, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, qr := range q.queued {
+		if qr.Id != id {
+			continue
+		}
+		q.queued = append(q.queued[:i], q.queued[i+1:]...)
+		qr.Cancelled = true
+
+		glog.Info("cancelled queued run ", id)
+
+		if qr.endChan != nil {
+			p := MakeParams()
+			p.FlowName = qr.launcher.Name
+			p.Status = CANCELLED
+			p.Response = "cancelled before it started"
+			go func() { qr.endChan <- p }()
+		}
+		return q.snapshot(qr), nil
+	}
+	return QueuedRun{}, errors.New("not in the queue: " + id)
+}
+
+// a copy of the queued run with its position - the lock must be held
+func (q *RunQueue) snapshot(qr *QueuedRun) QueuedRun {
+	c := *qr
+	c.Position = 0
+	for i, o := range q.queued {
+		if o == qr {
+			c.Position = i + 1
+		}
+	}
+	return c
+}
@@ -0,0 +1,94 @@
+package flow
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_RunQueue(t *testing.T) {
+	p := MakeProject("queue")
+	p.MaxRuns = 2
+	for _, name := range []string{"a", "b"} {
+		sf := &slowFlow{childFlow{dir: t.TempDir()}}
+		sf.Init(name)
+		p.AddFlow(MakeFlowLauncher(sf, 1, nil, nil))
+	}
+	p.FlowLaunchers["a"].MaxRuns = 1
+
+	q := NewRunQueue(p)
+
+	ends := map[string]chan *Params{}
+	submit := func(flowId string) QueuedRun {
+		ec := make(chan *Params, 1)
+		qr, err := q.Submit(flowId, "me", time.Millisecond, nil, ec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ends[qr.Id] = ec
+		return qr
+	}
+	wait := func(id string) *Params {
+		select {
+		case end := <-ends[id]:
+			return end
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not end ", id)
+		}
+		return nil
+	}
+
+	a1 := submit("a")
+	a2 := submit("a")
+	b1 := submit("b")
+	b2 := submit("b")
+
+	if a1.RunId == "" || b1.RunId == "" {
+		t.Error("expected the first run of each flow to start", a1, b1)
+	}
+	if a2.RunId != "" || a2.Position != 1 {
+		t.Error("expected the second run of a to wait for its flow", a2)
+	}
+	if b2.RunId != "" || b2.Position != 2 {
+		t.Error("expected the second run of b to wait for the project", b2)
+	}
+	if r := p.FlowLaunchers["a"].Run(a1.RunId); r == nil || r.User != "me" || a2.User != "me" {
+		t.Error("expected the user to be recorded on the run", r)
+	}
+	if q.Running("") != 2 || q.Running("a") != 1 {
+		t.Error("wrong running count", q.Running(""), q.Running("a"))
+	}
+
+	if _, err := q.Submit("nope", "me", 0, nil, nil); err == nil {
+		t.Error("expected an error queueing an unknown flow")
+	}
+
+	// cancel the run of b before it starts
+	if _, err := q.Cancel(b2.Id); err != nil {
+		t.Fatal(err)
+	}
+	if end := wait(b2.Id); end.Status != CANCELLED {
+		t.Error("expected the cancelled run to end cancelled", end.Status)
+	}
+	if queued := q.Queued(); len(queued) != 1 || queued[0].Id != a2.Id || queued[0].Position != 1 {
+		t.Error("expected just the second run of a in the queue", queued)
+	}
+
+	// the first run of a ending lets the second start
+	if end := wait(a1.Id); end.Status != SUCCESS {
+		t.Error("run failed", end.Status)
+	}
+	if end := wait(a2.Id); end.Status != SUCCESS {
+		t.Error("queued run failed", end.Status)
+	}
+	wait(b1.Id)
+
+	if len(q.Queued()) != 0 || q.Running("") != 0 {
+		t.Error("queue not empty", q.Queued(), q.Running(""))
+	}
+	if _, err := q.Cancel(a2.Id); err == nil {
+		t.Error("expected an error cancelling a run that has left the queue")
+	}
+	if len(p.FlowLaunchers["a"].ActiveRuns()) != 0 {
+		t.Error("runs still active")
+	}
+}
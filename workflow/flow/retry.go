@@ -0,0 +1,128 @@
+package flow
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+type Backoff int
+
+const (
+	BACKOFF_FIXED       Backoff = iota // wait Delay between every attempt
+	BACKOFF_EXPONENTIAL                // double the wait after each attempt - up to MaxDelay
+)
+
+// the cap on the exponential wait if the policy has no MaxDelay - so the doubling can not overflow
+const maxBackoff = time.Hour
+
+// a retry policy can be attached to a task node so that failed attempts are run again
+// before the final status is used to pick the next nodes
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts including the first one
+	Backoff        Backoff       // how the wait between attempts grows
+	Delay          time.Duration // wait before the first retry
+	MaxDelay       time.Duration // cap on the exponential wait - zero caps it at an hour
+	RetryOn        []int         // statuses that can be retried - defaults to FAIL and TIMEOUT
+	RetryExitCodes []int         // if set only failures with one of these exit codes are retried
+}
+
+// is the result of this attempt worth another go
+func (rp *RetryPolicy) retryable(p *Params) bool {
+	on := rp.RetryOn
+	if len(on) == 0 {
+		on = []int{FAIL, TIMEOUT}
+	}
+
+	if !containsInt(on, p.Status) {
+		return false
+	}
+
+	// exit codes only mean something for failed commands
+	if p.Status == FAIL && len(rp.RetryExitCodes) > 0 {
+		return containsInt(rp.RetryExitCodes, p.ExitStatus)
+	}
+
+	return true
+}
+
+// the wait after the given (1 based) attempt failed
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	d := rp.Delay
+	if rp.Backoff == BACKOFF_EXPONENTIAL {
+		max := rp.MaxDelay
+		if max <= 0 {
+			max = maxBackoff
+		}
+		for i := 1; i < attempt; i++ {
+			if d >= max/2 {
+				return max
+			}
+			d = d * 2
+		}
+	}
+	return d
+}
+
+func containsInt(l []int, v int) bool {
+	for _, i := range l {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+// attach a retry policy to this node
+func (tn *TaskNode) SetRetry(rp *RetryPolicy) {
+	tn.retry = rp
+}
+
+func (tn *TaskNode) Retry() *RetryPolicy {
+	return tn.retry
+}
+
+// execute the task as many times as the retry policy allows - each failed attempt is sent
+// to the flow status channel marked as Retrying, only the last attempt is returned
+func (tn *TaskNode) execAttempts(curPar *Params) *Params {
+	if tn.retry == nil || tn.retry.MaxAttempts < 2 {
+		return tn.execTask(curPar)
+	}
+
+	for attempt := 1; ; attempt++ {
+		attPar := MakeParams()
+		attPar.Copy(curPar)
+		attPar.TaskType = curPar.TaskType
+		attPar.Attempt = attempt
+
+		res := tn.execTask(attPar)
+		res.Attempt = attempt
+
+		if attempt >= tn.retry.MaxAttempts || !tn.retry.retryable(res) || tn.flow.Cancelled() {
+			return res
+		}
+
+		delay := tn.retry.backoff(attempt)
+		glog.Warning("attempt ", attempt, " of ", tn.Id(), " failed with status ", StatusName(res.Status), " - retrying in ", delay)
+
+		if tn.CommandStream != nil {
+			tn.CommandStream.Write([]byte(fmt.Sprintf("attempt %d of %d failed - retrying in %v\n", attempt, tn.retry.MaxAttempts, delay)))
+		}
+
+		// report this attempt
+		res.Retrying = true
+		tn.flow.send(res)
+
+		select {
+		case <-time.After(delay):
+		case <-tn.flow.Context().Done():
+			canPar := MakeParams()
+			canPar.Copy(res)
+			canPar.Attempt = attempt
+			canPar.Status = CANCELLED
+			canPar.Response = "flow stopped"
+			return canPar
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package flow
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fails until it has been run n times
+type flakyTask struct {
+	n    int32
+	runs int32
+}
+
+func (t *flakyTask) Type() string { return "flaky" }
+
+func (t *flakyTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	if atomic.AddInt32(&t.runs, 1) < t.n {
+		p.Status = FAIL
+		return
+	}
+	p.Status = SUCCESS
+}
+
+// fails with the exit status until it has run n times
+type exitTask struct {
+	n, exit int32
+	runs    int32
+}
+
+func (t *exitTask) Type() string { return "exit" }
+
+func (t *exitTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	if atomic.AddInt32(&t.runs, 1) < t.n {
+		p.Status = FAIL
+		p.ExitStatus = int(t.exit)
+		return
+	}
+	p.Status = SUCCESS
+}
+
+// run the task with the retry policy - returns the status it completed with and the attempts reported
+func runRetry(t *testing.T, rp *RetryPolicy, task Task) (int, []*Params) {
+	w := MakeWorkflow()
+	w.Name = "retry"
+	s := w.MakeTaskNode("s", task)
+	s.SetRetry(rp)
+	e := w.MakeTaskNode("e", nopTask{})
+	s.AddNext(SUCCESS, e)
+	s.AddNext(FAIL, e)
+	w.SetStart(s)
+	w.SetEnd(e)
+
+	attempts := []*Params{}
+	status := -1
+	done := make(chan bool)
+	go func() {
+		for p := range w.C {
+			if p.TaskId != "s" {
+				continue
+			}
+			if p.Retrying {
+				attempts = append(attempts, p)
+			} else if p.Complete {
+				status = p.Status
+			}
+		}
+		close(done)
+	}()
+	go func() {
+		for {
+			select {
+			case w.Stepper <- 1:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	p := MakeParams()
+	p.FlowName = w.Name
+	w.Exec(p)
+
+	select {
+	case <-e.DoneChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("flow did not finish")
+	}
+	close(w.C)
+	<-done
+	return status, attempts
+}
+
+func Test_RetryAttempts(t *testing.T) {
+	// succeeds on the third attempt
+	ft := &flakyTask{n: 3}
+	status, attempts := runRetry(t, &RetryPolicy{MaxAttempts: 5, Delay: time.Millisecond}, ft)
+	if status != SUCCESS || ft.runs != 3 || len(attempts) != 2 {
+		t.Error("expected success on the third attempt", status, ft.runs, len(attempts))
+	}
+	for i, a := range attempts {
+		if a.Attempt != i+1 || a.Status != FAIL {
+			t.Error("bad attempt", i, a.Attempt, a.Status)
+		}
+	}
+
+	// gives up after the last attempt
+	ft = &flakyTask{n: 5}
+	status, attempts = runRetry(t, &RetryPolicy{MaxAttempts: 3, Delay: time.Millisecond}, ft)
+	if status != FAIL || ft.runs != 3 || len(attempts) != 2 {
+		t.Error("expected failure after 3 attempts", status, ft.runs, len(attempts))
+	}
+}
+
+func Test_RetryBackoff(t *testing.T) {
+	exp := &RetryPolicy{Backoff: BACKOFF_EXPONENTIAL, Delay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	fixed := &RetryPolicy{Backoff: BACKOFF_FIXED, Delay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	uncapped := &RetryPolicy{Backoff: BACKOFF_EXPONENTIAL, Delay: 10 * time.Millisecond}
+
+	for attempt, want := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 5: 50} {
+		if d := exp.backoff(attempt); d != want*time.Millisecond {
+			t.Error("exponential backoff after attempt ", attempt, " was ", d)
+		}
+		if d := fixed.backoff(attempt); d != 10*time.Millisecond {
+			t.Error("fixed backoff after attempt ", attempt, " was ", d)
+		}
+	}
+	if d := uncapped.backoff(6); d != 320*time.Millisecond {
+		t.Error("backoff without a max delay was capped early", d)
+	}
+	for _, attempt := range []int{20, 64, 1000} {
+		if d := uncapped.backoff(attempt); d != maxBackoff {
+			t.Error("backoff without a max delay not capped after attempt ", attempt, " was ", d)
+		}
+	}
+}
+
+func Test_RetryOn(t *testing.T) {
+	// only timeouts are retried
+	ft := &flakyTask{n: 3}
+	status, attempts := runRetry(t, &RetryPolicy{MaxAttempts: 5, RetryOn: []int{TIMEOUT}}, ft)
+	if status != FAIL || ft.runs != 1 || len(attempts) != 0 {
+		t.Error("expected a failure not to be retried", status, ft.runs, len(attempts))
+	}
+
+	// only failures with exit status 2 are retried
+	et := &exitTask{n: 3, exit: 1}
+	status, _ = runRetry(t, &RetryPolicy{MaxAttempts: 5, RetryExitCodes: []int{2}}, et)
+	if status != FAIL || et.runs != 1 {
+		t.Error("expected exit status 1 not to be retried", status, et.runs)
+	}
+	et = &exitTask{n: 3, exit: 2}
+	status, _ = runRetry(t, &RetryPolicy{MaxAttempts: 5, RetryExitCodes: []int{2}}, et)
+	if status != SUCCESS || et.runs != 3 {
+		t.Error("expected exit status 2 to be retried", status, et.runs)
+	}
+
+	// the default is fail and timeout but not cancelled
+	rp := &RetryPolicy{}
+	for st, want := range map[int]bool{FAIL: true, TIMEOUT: true, CANCELLED: false, SUCCESS: false} {
+		if got := rp.retryable(&Params{Status: st}); got != want {
+			t.Error("status ", st, " retryable ", got)
+		}
+	}
+}
@@ -1,21 +1,411 @@
 package flow
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/golang/glog"
 )
 
-// TODO - not used yet - but this would be the historical set of lauch results for a given flow
+// A Run is one start of a flow launcher - it has its own threads, channels, workspace and results so
+// the same launcher can run many times at once. Runs are kept in the flows RunList.
 type Run struct {
-	Id     string
-	Result *FlowLaunchResult
+	Id        string // <flow-id>-<number>
+	FlowId    string
+	Number    int    // counts up from 1 for each flow
+	User      string // who started the run - empty if it was not started by someone e.g. a trigger
+	Start     time.Time
+	End       time.Time
+	Running   bool
+	Status    int
+	Cancelled bool
+	Error     string
+	Props     Props             // the props the run started with - including its workspace
+	EndParams *Params           // what the flow ended with
+	Result    *FlowLaunchResult // the stats and command output of each task
+
+	// the state of a run in progress
+	launcher  *FlowLauncher
+	flows     []*Workflow // each thread creates a full workflow in memory - so the implementor of tasks does not have to wory about thread conflicts
+	cstat     chan *Params
+	iEnd      chan *Params // internal end chanel for auto stepper
+	endParams *Params
+	initial   *Run               // the run of the initial flow
+	debug     *Debugger          // set when the run was started in debug mode
+	fed       []Props            // the props from the feeder by thread id
+	stopLoad  context.CancelFunc // stops a load run starting any more flows
+	lock      sync.Mutex         // guards the flows and the end params as the threads finish while they are being stepped
+}
+
+// the run without its results - for listing runs
+type RunSummary struct {
+	Id        string
+	FlowId    string
+	Number    int
+	User      string
+	Start     time.Time
+	End       time.Time
+	Running   bool
+	Status    int
+	Cancelled bool
+	Error     string
+	Props     Props
+}
+
+func (r *Run) Summary() RunSummary {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return RunSummary{
+		Id:        r.Id,
+		FlowId:    r.FlowId,
+		Number:    r.Number,
+		User:      r.User,
+		Start:     r.Start,
+		End:       r.End,
+		Running:   r.Running,
+		Status:    r.Status,
+		Cancelled: r.Cancelled,
+		Error:     r.Error,
+		Props:     r.Props,
+	}
+}
+
+// the json of the run - taken under the lock as a run in progress is still being written to
+func (r *Run) MarshalJSON() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return json.Marshal((*run)(r))
+}
+
+// the fields of a run without its MarshalJSON
+type run Run
+
+// the debugger of the run - nil if it was not started in debug mode
+func (r *Run) Debugger() *Debugger {
+	return r.debug
+}
+
+// the results of the run - nil until it has started its threads
+func (r *Run) CurrentResult() *FlowLaunchResult {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.Result
+}
+
+// make fresh chanels for the run - this must be done before the auto stepper and exec are started as
+// they both use them
+func (r *Run) makeChannels() {
+	r.cstat = make(chan *Params)
+	r.iEnd = make(chan *Params)
 }
 
+// the workflows of the threads that have started
+func (r *Run) activeFlows() []*Workflow {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	flows := []*Workflow{}
+	for _, f := range r.flows {
+		if f != nil {
+			flows = append(flows, f)
+		}
+	}
+	return flows
+}
+
+func (r *Run) step(v int) {
+	flows := r.activeFlows()
+
+	// cant step if there was a problem and we didnt make all threads
+	if len(flows) == 0 {
+		glog.Error("no threads to step ", r.Id)
+		return
+	}
+	glog.Info("<<<<<<<<<<<<<<<<<<<<<<<<<<< step")
+	for _, f := range flows {
+		// a thread that has finished or been stopped will never take the step
+		select {
+		case f.Stepper <- v:
+		case <-f.Context().Done():
+		}
+	}
+}
+
+// cancel the run - which kills any running tasks and stops a load run starting any more
+func (r *Run) stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.Cancelled = true
+	if r.Result != nil {
+		r.Result.cancel()
+	}
+	if r.stopLoad != nil {
+		r.stopLoad()
+	}
+	if r.initial != nil {
+		r.initial.stop()
+	}
+	for _, f := range r.flows {
+		if f != nil {
+			f.Cancel()
+		}
+	}
+}
+
+func (r *Run) setError(msg string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Error = msg
+	if r.Result != nil {
+		r.Result.setError(msg)
+	}
+}
+
+// a copy of the props of the run for the thread - with the values of its matrix cell and from the
+// feeder, a thread id below zero is just the run props
+func (r *Run) threadProps(i int) Props {
+	props := Props{}
+	for k, v := range r.Props {
+		props[k] = v
+	}
+	if i < 0 {
+		return props
+	}
+	if cells := r.launcher.cells; i < len(cells) {
+		for k, v := range cells[i].Props {
+			props[k] = v
+		}
+	}
+	if i < len(r.fed) {
+		for k, v := range r.fed[i] {
+			props[k] = v
+		}
+	}
+	return props
+}
+
+// the historical set of runs of a flow - oldest first - saved in its store as they are added
 type RunList struct {
-	Name string
+	Name  string
+	Runs  []*Run
+	Keep  int // the most runs to keep - the oldest that have ended are deleted when there are more, zero keeps them all
+	last  int // the highest run number given out - it never goes down so the ids of deleted runs are not used again
+	store RunStore
+	lock  sync.Mutex
+}
+
+// make the run list for the flow loading any runs already in the store
+func NewRunList(flowId string, store RunStore) (*RunList, error) {
+	rl := &RunList{
+		Name:  flowId,
+		Runs:  []*Run{},
+		store: store,
+	}
 
-	Runs []Run // a set of response stats by task id in our workflow for the last run
+	if store == nil {
+		return rl, nil
+	}
+
+	runs, err := store.Load(flowId)
+	if err != nil {
+		return rl, err
+	}
+
+	// runs that were going when the agent stopped never ended
+	for _, r := range runs {
+		if r.Running {
+			r.Running = false
+			r.Cancelled = true
+			if r.Error == "" {
+				r.Error = "the run was still going when the agent stopped"
+			}
+		}
+	}
+	rl.Runs = runs
+
+	if n := len(runs); n > 0 {
+		rl.last = runs[n-1].Number
+	}
+	last, err := store.LastNumber(flowId)
+	if err != nil {
+		return rl, err
+	}
+	if last > rl.last {
+		rl.last = last
+	}
+	return rl, nil
+}
+
+// keep no more than n runs - deleting the oldest runs that have ended now and as new runs are added
+func (rl *RunList) SetKeep(n int) error {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.Keep = n
+	return rl.prune()
+}
+
+// delete the oldest runs that have ended until there are no more than Keep - the lock must be held
+func (rl *RunList) prune() error {
+	if rl.Keep <= 0 {
+		return nil
+	}
+	var lastErr error
+	for i := 0; len(rl.Runs) > rl.Keep && i < len(rl.Runs); {
+		r := rl.Runs[i]
+		r.lock.Lock()
+		running := r.Running
+		r.lock.Unlock()
+		if running {
+			i++
+			continue
+		}
+		if rl.store != nil {
+			if err := rl.store.Delete(rl.Name, r.Number); err != nil {
+				glog.Error("could not delete old run ", r.Id, " ", err)
+				lastErr = err
+				i++
+				continue
+			}
+		}
+		rl.Runs = append(rl.Runs[:i], rl.Runs[i+1:]...)
+		glog.Info("dropped old run ", r.Id)
+	}
+	return lastErr
+}
+
+// give the run its id and keep it - the run is still added if it could not be saved. A run in
+// progress is saved again with Save when it ends.
+func (rl *RunList) AddRun(r *Run) error {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	rl.last++
+	r.FlowId = rl.Name
+	r.Number = rl.last
+	r.Id = fmt.Sprintf("%s-%d", rl.Name, r.Number)
+	rl.Runs = append(rl.Runs, r)
+
+	glog.Info("add run ", r.Id)
+
+	pruneErr := rl.prune()
+
+	if rl.store == nil {
+		return pruneErr
+	}
+	if err := rl.store.SetLastNumber(rl.Name, rl.last); err != nil {
+		return err
+	}
+	if err := rl.store.Save(r); err != nil {
+		return err
+	}
+	return pruneErr
+}
+
+// save the run as it is now - the run is marshalled under its own lock
+func (rl *RunList) Save(r *Run) error {
+	if rl.store == nil {
+		return nil
+	}
+	return rl.store.Save(r)
 }
 
-func (rl *RunList) AddRun(result *FlowLaunchResult) {
-	glog.Info("blah")
+// the run with this id - nil if there is none
+func (rl *RunList) Get(id string) *Run {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	for _, r := range rl.Runs {
+		if r.Id == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// the last run - nil if the flow has never run
+func (rl *RunList) Latest() *Run {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if len(rl.Runs) == 0 {
+		return nil
+	}
+	return rl.Runs[len(rl.Runs)-1]
+}
+
+// a copy of the list of runs - oldest first
+func (rl *RunList) All() []*Run {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return append([]*Run{}, rl.Runs...)
+}
+
+// a page of the runs - newest first - that ended with one of the statuses (any status if there are
+// none), pages count from 1. Also returns how many runs matched in all.
+func (rl *RunList) Page(statuses []int, page, perPage int) ([]*Run, int) {
+	matched := []*Run{}
+	all := rl.All()
+	for i := len(all) - 1; i >= 0; i-- {
+		r := all[i]
+		if len(statuses) > 0 && !r.hasStatus(statuses) {
+			continue
+		}
+		matched = append(matched, r)
+	}
+
+	from := (page - 1) * perPage
+	if page < 1 || perPage < 1 || from >= len(matched) {
+		return []*Run{}, len(matched)
+	}
+	to := from + perPage
+	if to > len(matched) {
+		to = len(matched)
+	}
+	return matched[from:to], len(matched)
+}
+
+// a run in progress has no status yet so never matches
+func (r *Run) hasStatus(statuses []int) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.Running {
+		return false
+	}
+	for _, s := range statuses {
+		if r.Status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// forget the run and take it out of the store - a run in progress can not be deleted
+func (rl *RunList) Delete(id string) error {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	for i, r := range rl.Runs {
+		if r.Id != id {
+			continue
+		}
+		r.lock.Lock()
+		running := r.Running
+		r.lock.Unlock()
+		if running {
+			return errors.New("run in progress: " + id)
+		}
+
+		if rl.store != nil {
+			if err := rl.store.Delete(rl.Name, r.Number); err != nil {
+				return err
+			}
+		}
+		rl.Runs = append(rl.Runs[:i], rl.Runs[i+1:]...)
+
+		glog.Info("deleted run ", id)
+		return nil
+	}
+	return errors.New("run not found: " + id)
 }
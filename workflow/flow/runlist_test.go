@@ -0,0 +1,350 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_RunHistory(t *testing.T) {
+	cf := &childFlow{dir: t.TempDir()}
+	cf.Init("history")
+	l := MakeFlowLauncher(cf, 1, nil, nil)
+
+	store := NewFileRunStore(filepath.Join(cf.dir, "history"))
+	rl, err := NewRunList(l.Id, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetHistory(rl)
+
+	for _, v := range []string{"1.0", "2.0"} {
+		ec := make(chan *Params, 1)
+		go l.StartWith(time.Millisecond, Props{"version": v}, ec)
+		select {
+		case <-ec:
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not end")
+		}
+	}
+
+	runs := rl.All()
+	if len(runs) != 2 || runs[0].Id != "history-1" || runs[1].Id != "history-2" {
+		t.Fatal("runs not kept in order", runs)
+	}
+	if rl.Get("history-2") != runs[1] || rl.Latest() != runs[1] || rl.Get("nope") != nil {
+		t.Error("runs not found by id")
+	}
+
+	// as if the agent restarted
+	p := MakeProject("history")
+	p.AddFlow(MakeFlowLauncher(cf, 1, nil, nil))
+	if err := p.LoadHistory(store); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := p.RunList["history"].All()
+	if len(loaded) != 2 {
+		t.Fatal("expected the runs to be loaded", len(loaded))
+	}
+	r := loaded[1]
+	if r.Number != 2 || r.Status != SUCCESS || r.Start.IsZero() || r.End.Before(r.Start) {
+		t.Error("bad run", r.Number, r.Status, r.Start, r.End)
+	}
+	if r.Props["version"] != "2.0" || r.Result.Outputs["build.version"] != "2.0" {
+		t.Error("run props or outputs not kept", r.Props, r.Result.Outputs)
+	}
+	if r.Result.Results["build"].Stats.Latency.Count != 1 {
+		t.Error("task stats not kept")
+	}
+	if to, ok := r.Result.TaskOutput("build"); !ok || to.Outputs["build.version"] != "2.0" {
+		t.Error("task output not found", to)
+	}
+	if _, ok := r.Result.TaskOutput("nope"); ok {
+		t.Error("expected no output for a missing task")
+	}
+	if p.FlowLaunchers["history"].LastRunResult != r.Result {
+		t.Error("last run not restored as the last result")
+	}
+
+	// the numbers carry on after a restart
+	if err := p.RunList["history"].AddRun(&Run{Status: FAIL}); err != nil {
+		t.Fatal(err)
+	}
+	if p.RunList["history"].Latest().Id != "history-3" {
+		t.Error("run numbers did not carry on", p.RunList["history"].Latest().Id)
+	}
+}
+
+func Test_RunHistoryPages(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileRunStore(dir)
+	rl, _ := NewRunList("pages", store)
+
+	// runs 1 to 7 alternating success and fail - and a run in progress
+	for i := 1; i <= 7; i++ {
+		st := SUCCESS
+		if i%2 == 0 {
+			st = FAIL
+		}
+		if err := rl.AddRun(&Run{Status: st}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rl.AddRun(&Run{Running: true})
+
+	runs, total := rl.Page(nil, 1, 3)
+	if total != 8 || len(runs) != 3 || runs[0].Id != "pages-8" || runs[2].Id != "pages-6" {
+		t.Error("bad first page", total, runs)
+	}
+	runs, _ = rl.Page(nil, 3, 3)
+	if len(runs) != 2 || runs[1].Id != "pages-1" {
+		t.Error("bad last page", runs)
+	}
+	if runs, _ = rl.Page(nil, 4, 3); len(runs) != 0 {
+		t.Error("expected an empty page past the end", runs)
+	}
+
+	runs, total = rl.Page([]int{FAIL}, 1, 10)
+	if total != 3 || runs[0].Id != "pages-6" || runs[2].Id != "pages-2" {
+		t.Error("bad status filter", total, runs)
+	}
+	if _, total = rl.Page([]int{FAIL, SUCCESS}, 1, 10); total != 7 {
+		t.Error("expected the run in progress to be left out", total)
+	}
+
+	if err := rl.Delete("pages-6"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pages", "6.json")); rl.Get("pages-6") != nil || !os.IsNotExist(err) {
+		t.Error("run not deleted")
+	}
+	if rl.Delete("pages-6") == nil || rl.Delete("pages-8") == nil {
+		t.Error("expected an error deleting a missing run or one in progress")
+	}
+
+	loaded, _ := NewRunList("pages", store)
+	if len(loaded.All()) != 7 {
+		t.Error("deleted run loaded again", len(loaded.All()))
+	}
+}
+
+func Test_RunNumbersAndKeep(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileRunStore(dir)
+	rl, _ := NewRunList("keep", store)
+
+	for i := 0; i < 3; i++ {
+		rl.AddRun(&Run{})
+	}
+
+	// deleting the newest run does not free its number - even after a restart
+	if err := rl.Delete("keep-3"); err != nil {
+		t.Fatal(err)
+	}
+	r := &Run{}
+	rl.AddRun(r)
+	if r.Id != "keep-4" {
+		t.Error("run number reused", r.Id)
+	}
+	rl.Delete("keep-4")
+	rl, _ = NewRunList("keep", store)
+	r = &Run{}
+	rl.AddRun(r)
+	if r.Id != "keep-5" {
+		t.Error("run number reused after loading", r.Id)
+	}
+
+	// only the newest runs are kept - but never one in progress
+	rl.AddRun(&Run{})
+	if err := rl.SetKeep(2); err != nil {
+		t.Fatal(err)
+	}
+	if runs := rl.All(); len(runs) != 2 || runs[0].Id != "keep-5" {
+		t.Error("old runs not dropped", runs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep", "1.json")); !os.IsNotExist(err) {
+		t.Error("old run not deleted from the store")
+	}
+	rl.AddRun(&Run{Running: true})
+	rl.AddRun(&Run{})
+	if runs := rl.All(); len(runs) != 2 || runs[0].Id != "keep-7" || runs[1].Id != "keep-8" {
+		t.Error("expected the run in progress and the newest run", runs)
+	}
+}
+
+type slowFlow struct {
+	childFlow
+}
+
+func (c *slowFlow) FlowFunc(threadId int) *Workflow {
+	w := MakeWorkflow()
+	s := w.MakeTaskNode("sleep", sleepTask{d: 100 * time.Millisecond})
+	b := w.MakeTaskNode("build", versionTask{})
+	s.AddNext(SUCCESS, b)
+	w.SetStart(s)
+	w.SetEnd(b)
+	return w
+}
+
+func Test_ConcurrentRuns(t *testing.T) {
+	sf := &slowFlow{childFlow{dir: t.TempDir()}}
+	sf.Init("slow")
+	l := MakeFlowLauncher(sf, 1, nil, nil)
+	rl, _ := NewRunList(l.Id, nil)
+	l.SetHistory(rl)
+
+	wait := func(ec chan *Params) *Params {
+		select {
+		case end := <-ec:
+			return end
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not end")
+		}
+		return nil
+	}
+
+	ec1, ec2 := make(chan *Params, 1), make(chan *Params, 1)
+	r1 := l.StartRun(time.Millisecond, Props{"version": "a"}, ec1)
+	r2 := l.StartRun(time.Millisecond, Props{"version": "b"}, ec2)
+
+	if r1.Id == r2.Id || r1.Props[KEY_WORKSPACE] == r2.Props[KEY_WORKSPACE] {
+		t.Error("runs share an id or workspace", r1.Id, r2.Id, r1.Props[KEY_WORKSPACE])
+	}
+	if len(l.ActiveRuns()) != 2 || l.Run(r2.Id) != r2 {
+		t.Error("expected both runs to be active")
+	}
+
+	if e1, e2 := wait(ec1), wait(ec2); e1.Status != SUCCESS || e2.Status != SUCCESS {
+		t.Fatal("runs failed", e1.Status, e2.Status)
+	}
+
+	if r1.Result == r2.Result || r1.Result.Outputs["build.version"] != "a" || r2.Result.Outputs["build.version"] != "b" {
+		t.Error("runs did not keep their own results", r1.Result.Outputs, r2.Result.Outputs)
+	}
+	if r1.Running || r2.Running || len(l.ActiveRuns()) != 0 {
+		t.Error("runs still active")
+	}
+	if l.Run(r1.Id) != r1 {
+		t.Error("finished run not found in the history")
+	}
+
+	// the workspace is free again once its run is over
+	ec3, ec4 := make(chan *Params, 1), make(chan *Params, 1)
+	r3 := l.StartRun(time.Millisecond, nil, ec3)
+	r4 := l.StartRun(time.Millisecond, nil, ec4)
+	if r3.Props[KEY_WORKSPACE] != r1.Props[KEY_WORKSPACE] {
+		t.Error("workspace not reused", r3.Props[KEY_WORKSPACE])
+	}
+
+	// stopping one run leaves the other alone
+	time.Sleep(20 * time.Millisecond)
+	if err := l.StopRun(r3.Id); err != nil {
+		t.Fatal(err)
+	}
+	if e3, e4 := wait(ec3), wait(ec4); e3.Status != CANCELLED || e4.Status != SUCCESS {
+		t.Error("expected only the stopped run to be cancelled", e3.Status, e4.Status)
+	}
+	if !r3.Cancelled || r4.Cancelled {
+		t.Error("wrong run marked cancelled")
+	}
+	if l.StopRun(r3.Id) == nil {
+		t.Error("expected an error stopping a finished run")
+	}
+}
+
+func Test_MarshalRunInProgress(t *testing.T) {
+	sf := &slowFlow{childFlow{dir: t.TempDir()}}
+	sf.Init("slow")
+	l := MakeFlowLauncher(sf, 4, nil, nil)
+	ec := make(chan *Params, 1)
+	r := l.StartRun(time.Millisecond, Props{"version": "a"}, ec)
+
+	// the threads keep writing the run and its results while they are read
+	timeout := time.After(5 * time.Second)
+	for {
+		if _, err := json.Marshal(r); err != nil {
+			t.Fatal(err)
+		}
+		if res := r.CurrentResult(); res != nil {
+			if _, err := json.Marshal(res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		select {
+		case <-ec:
+			b, _ := json.Marshal(r)
+			got := &Run{}
+			if err := json.Unmarshal(b, got); err != nil || got.Id != r.Id || got.Result.Outputs["build.version"] != "a" {
+				t.Error("run did not round trip", err, string(b))
+			}
+			return
+		case <-timeout:
+			t.Fatal("run did not end")
+		default:
+		}
+	}
+}
+
+// fails in every thread but the first
+type threadTask struct{}
+
+func (t threadTask) Type() string { return "thread" }
+
+func (t threadTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	p.Status = SUCCESS
+	if p.ThreadId > 0 {
+		p.Status = FAIL
+	}
+}
+
+type threadFlow struct {
+	childFlow
+}
+
+func (c *threadFlow) FlowFunc(threadId int) *Workflow {
+	w := MakeWorkflow()
+	b := w.MakeTaskNode("build", threadTask{})
+	w.SetStart(b)
+	w.SetEnd(b)
+	return w
+}
+
+func Test_RunStatusOfThreads(t *testing.T) {
+	tf := &threadFlow{childFlow{dir: t.TempDir()}}
+	tf.Init("threads")
+	rl, _ := NewRunList("threads", nil)
+
+	for threads, want := range map[int]int{1: SUCCESS, 2: FAIL, 3: FAIL, 4: FAIL} {
+		l := MakeFlowLauncher(tf, threads, nil, nil)
+		l.SetHistory(rl)
+		ec := make(chan *Params, 1)
+		r := l.StartRun(time.Millisecond, nil, ec)
+		select {
+		case end := <-ec:
+			if end.Status != want {
+				t.Error(threads, " threads ended with ", end.Status, " not ", want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("run did not end")
+		}
+		if s := r.Summary(); s.Status != want {
+			t.Error(threads, " threads run has status ", s.Status, " not ", want)
+		}
+	}
+
+	// the history filter sees the status of the run not the sum of its threads
+	if _, total := rl.Page([]int{FAIL}, 1, 10); total != 3 {
+		t.Error("expected 3 failed runs", total)
+	}
+	if _, total := rl.Page([]int{SUCCESS}, 1, 10); total != 1 {
+		t.Error("expected 1 run that succeeded", total)
+	}
+	if _, total := rl.Page([]int{WORKING, LOOP}, 1, 10); total != 0 {
+		t.Error("expected no runs with a summed status", total)
+	}
+}
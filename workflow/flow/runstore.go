@@ -0,0 +1,124 @@
+package flow
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// A RunStore keeps the history of runs so it survives a restart - the file store is the only one so
+// far but anything that can save and load a run by flow could be used e.g. a database
+type RunStore interface {
+	Save(r *Run) error
+	Load(flowId string) ([]*Run, error) // all the runs of the flow oldest first
+	Delete(flowId string, number int) error
+	LastNumber(flowId string) (int, error) // the highest run number given out - zero if there has not been a run
+	SetLastNumber(flowId string, number int) error
+}
+
+// FileRunStore saves each run as json in <dir>/<flow-id>/<number>.json and the last run number in
+// <dir>/<flow-id>/last
+type FileRunStore struct {
+	Dir string
+}
+
+func NewFileRunStore(dir string) *FileRunStore {
+	return &FileRunStore{Dir: dir}
+}
+
+func (s *FileRunStore) path(flowId string, number int) string {
+	return filepath.Join(s.Dir, flowId, strconv.Itoa(number)+".json")
+}
+
+func (s *FileRunStore) Save(r *Run) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(r.FlowId, r.Number)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+
+	// write it whole or not at all
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// a flow that has not run yet has no runs - runs that can not be read are skipped
+func (s *FileRunStore) Load(flowId string) ([]*Run, error) {
+	runs := []*Run{}
+
+	files, err := ioutil.ReadDir(filepath.Join(s.Dir, flowId))
+	if os.IsNotExist(err) {
+		return runs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(s.Dir, flowId, name))
+		if err != nil {
+			glog.Warning("could not read run ", name, " ", err)
+			continue
+		}
+		r := &Run{}
+		if err := json.Unmarshal(b, r); err != nil {
+			glog.Warning("bad run ", name, " ", err)
+			continue
+		}
+		runs = append(runs, r)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Number < runs[j].Number
+	})
+	return runs, nil
+}
+
+func (s *FileRunStore) LastNumber(flowId string) (int, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.Dir, flowId, "last"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func (s *FileRunStore) SetLastNumber(flowId string, number int) error {
+	path := filepath.Join(s.Dir, flowId, "last")
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(number)), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// deleting a run that is not there is not an error
+func (s *FileRunStore) Delete(flowId string, number int) error {
+	err := os.Remove(s.path(flowId, number))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
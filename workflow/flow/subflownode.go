@@ -0,0 +1,275 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// how a sub flow node runs its launcher
+type SubFlowSpec struct {
+	In        map[string]string // props for the child run - the values can use ${...} variables from this node's params
+	Out       map[string]string // outputs of this node taken from the child's outputs (<task-id>.<key>) - empty means all of them
+	StepDelay time.Duration     // how often the child run is stepped - default is a second
+}
+
+// a run of another launcher started by a sub flow node - so the parents results can link to it. The
+// child run keeps its own results - look them up with Project.Run(RunId).
+type SubFlowRun struct {
+	FlowId   string
+	RunId    string
+	ThreadId int
+}
+
+// A sub flow node runs another launcher (with all its threads and any initial flow) as a single
+// step of this flow. Each thread that reaches the node starts its own run of the launcher. The node
+// completes with SUCCESS if the child run succeeded otherwise FAIL. Child runs start straight away
+// rather than waiting in a RunQueue - they are part of the parents run so they don't count towards
+// the MaxRuns of the project or the child flow.
+type SubFlowNode struct {
+	name      string
+	id        string
+	flow      *Workflow
+	C         chan *Params
+	launcher  *FlowLauncher
+	spec      SubFlowSpec
+	Outs      map[int][]TriggeredTaskNode
+	merges    []*MergeNode
+	runLock   sync.Mutex
+	cancelRun context.CancelFunc
+}
+
+func (tn *SubFlowNode) SetMergeTrigger(m *MergeNode) {
+	tn.merges = append(tn.merges, m)
+}
+
+func (tn *SubFlowNode) SetWorkFlow(f *Workflow) {
+	tn.flow = f
+}
+
+func (tn *SubFlowNode) WorkFlow() *Workflow {
+	return tn.flow
+}
+
+func (tn *SubFlowNode) DoneChan() chan *Params {
+	return tn.C
+}
+
+func (tn *SubFlowNode) FireDoneChan(p *Params) {
+	tn.C <- p
+}
+
+func (tn *SubFlowNode) Name() string {
+	return tn.name
+}
+
+func (tn *SubFlowNode) Id() string {
+	return tn.id
+}
+
+func (tn *SubFlowNode) Type() string {
+	return "subflow"
+}
+
+// the child run has its own results
+func (tn *SubFlowNode) SetStream(cs *io.PipeWriter) {}
+
+func (tn *SubFlowNode) Launcher() *FlowLauncher {
+	return tn.launcher
+}
+
+// stop the child run - e.g. a merge node no longer needs it
+func (tn *SubFlowNode) Cancel() {
+	tn.runLock.Lock()
+	defer tn.runLock.Unlock()
+	if tn.cancelRun != nil {
+		tn.cancelRun()
+	}
+}
+
+// add a node to fire when the child run is done with the given status
+func (tn *SubFlowNode) AddNext(forStatus int, t TriggeredTaskNode) error {
+	if tn.flow == nil {
+		return errors.New("can't add next nodes if current flow not set")
+	}
+
+	if t.WorkFlow() != tn.flow {
+		panic("next nodes have to be in the same workflow")
+	}
+
+	if tn.Outs == nil {
+		tn.Outs = make(map[int][]TriggeredTaskNode)
+	}
+
+	tn.flow.registerNode(t)
+	tn.Outs[forStatus] = append(tn.Outs[forStatus], t)
+
+	return nil
+}
+
+func (tn *SubFlowNode) Edges() []Edge {
+	edges := make([]Edge, 0, 1)
+	for val, x := range tn.Outs {
+		for _, xi := range x {
+			edges = append(edges, Edge{Name: fmt.Sprintf("%v", val), Status: val, From: tn.Id(), To: xi.Id()})
+		}
+	}
+	return edges
+}
+
+func (tn *SubFlowNode) Exec(inPar *Params) {
+	if inPar == nil {
+		glog.Error("ooo - you cant have null parameters")
+		return
+	}
+
+	curPar := MakeParams()
+	curPar.Copy(inPar)
+	curPar.TaskName = tn.Name()
+	curPar.TaskId = tn.Id()
+	curPar.TaskType = tn.Type()
+
+	// wait for the stepper (or the debugger) - unless the flow was stopped in the meantime
+	if !tn.flow.waitStep(curPar) {
+		curPar.Status = CANCELLED
+		curPar.Response = "flow stopped"
+		curPar.Complete = true
+		select {
+		case tn.flow.End.DoneChan() <- curPar:
+		default:
+		}
+		return
+	}
+
+	// mark the start
+	curPar.Started = time.Now()
+	startPar := MakeParams()
+	startPar.Copy(curPar)
+	startPar.Started = curPar.Started
+	tn.flow.send(startPar)
+
+	tn.run(curPar)
+	curPar.Ended = time.Now()
+	curPar.publishOutputs(tn.Id())
+	curPar.Complete = true
+
+	if tn.flow.Cancelled() {
+		curPar.Status = CANCELLED
+		select {
+		case tn.flow.End.DoneChan() <- curPar:
+		default:
+		}
+		return
+	}
+
+	glog.Info("sub flow ", tn.Id(), " finished with status ", curPar.Status)
+	tn.flow.send(curPar)
+
+	if tn.C != nil {
+		if len(tn.C) > 0 {
+			<-tn.C
+		}
+		tn.C <- curPar
+	}
+
+	for _, m := range tn.merges {
+		m.arrive(tn.Id(), curPar)
+	}
+
+	next := tn.Outs[curPar.Status]
+	for _, n := range next {
+		go n.Exec(curPar)
+	}
+
+	if len(next) == 0 && len(tn.merges) == 0 && TriggeredTaskNode(tn) != tn.flow.End {
+		glog.Warning("problem - dead end sub flow - this workflow may not of ended properly")
+		tn.flow.End.FireDoneChan(curPar)
+	}
+}
+
+// run the child launcher and fill in the status and outputs
+func (tn *SubFlowNode) run(curPar *Params) {
+	props := Props{}
+	for k, v := range tn.spec.In {
+		ev, err := curPar.Expand(v)
+		if err != nil {
+			curPar.Status = FAIL
+			curPar.Response = fmt.Sprintf("prop %s: %v", k, err)
+			return
+		}
+		props[k] = ev
+	}
+
+	delay := tn.spec.StepDelay
+	if delay == 0 {
+		delay = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(tn.flow.Context())
+	defer cancel()
+
+	tn.runLock.Lock()
+	tn.cancelRun = cancel
+	tn.runLock.Unlock()
+
+	l := tn.launcher
+	ec := make(chan *Params, 1)
+	r := l.StartRun(delay, props, ec)
+
+	glog.Info("sub flow ", tn.Id(), " started ", r.Id)
+
+	var end *Params
+	select {
+	case end = <-ec:
+	case <-ctx.Done():
+		l.StopRun(r.Id)
+		end = <-ec
+	}
+
+	curPar.subRun = &SubFlowRun{
+		FlowId:   l.Id,
+		RunId:    r.Id,
+		ThreadId: curPar.ThreadId,
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		curPar.Status = CANCELLED
+		curPar.Response = "sub flow stopped"
+		return
+	case end.Status != SUCCESS:
+		curPar.Status = FAIL
+		curPar.Response = "sub flow " + r.Id + " failed"
+		if msg := r.Summary().Error; msg != "" {
+			curPar.Response += ": " + msg
+		}
+	default:
+		curPar.Status = SUCCESS
+	}
+
+	res := r.CurrentResult()
+	if res == nil {
+		return
+	}
+
+	if len(tn.spec.Out) == 0 {
+		for k, v := range res.Outputs {
+			curPar.SetOutput(k, v)
+		}
+		return
+	}
+
+	for k, from := range tn.spec.Out {
+		v, ok := res.Outputs[from]
+		if !ok {
+			glog.Warning("sub flow ", l.Id, " did not output ", from)
+			continue
+		}
+		curPar.SetOutput(k, v)
+	}
+}
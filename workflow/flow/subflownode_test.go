@@ -0,0 +1,97 @@
+package flow
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// outputs the version prop it was given
+type versionTask struct{}
+
+func (t versionTask) Type() string { return "version" }
+
+func (t versionTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	p.SetOutput("version", p.Props["version"])
+	p.Status = SUCCESS
+}
+
+type childFlow struct {
+	BaseLaunchable
+	dir string
+}
+
+func (c *childFlow) GetProps() *Props {
+	return &Props{
+		KEY_WORKSPACE: filepath.Join(c.dir, "ws"),
+		KEY_TRIGGERS:  filepath.Join(c.dir, "triggers"),
+		KEY_TIDY_DESK: "keep",
+	}
+}
+
+func (c *childFlow) FlowFunc(threadId int) *Workflow {
+	w := MakeWorkflow()
+	b := w.MakeTaskNode("build", versionTask{})
+	w.SetStart(b)
+	w.SetEnd(b)
+	return w
+}
+
+func Test_SubFlow(t *testing.T) {
+	cf := &childFlow{dir: t.TempDir()}
+	cf.Init("child")
+	child := MakeFlowLauncher(cf, 1, nil, nil)
+	rl, err := NewRunList(child.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child.SetHistory(rl)
+
+	w := MakeWorkflow()
+	w.Name = "parent"
+	s := w.MakeTaskNode("s", nopTask{})
+	sub, err := w.MakeSubFlowNode("sub", child, SubFlowSpec{
+		In:        map[string]string{"version": "1.${thread-id}"},
+		Out:       map[string]string{"v": "build.version"},
+		StepDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := w.MakeTaskNode("e", nopTask{})
+	s.AddNext(SUCCESS, sub)
+	sub.AddNext(SUCCESS, e)
+	w.SetStart(s)
+	w.SetEnd(e)
+
+	statuses, end := runTestFlow(t, w, e)
+
+	if end.Props["sub.v"] != "1.0" {
+		t.Error("child output not mapped back", end.Props)
+	}
+
+	var subPar *Params
+	for _, p := range statuses {
+		if p.TaskId == "sub" {
+			subPar = p
+		}
+	}
+	if subPar == nil || subPar.subRun == nil || subPar.subRun.FlowId != "child" {
+		t.Fatal("child run not linked")
+	}
+
+	// the child results are kept once - with the child run
+	cr := child.Run(subPar.subRun.RunId)
+	if cr == nil || cr.CurrentResult() == nil || cr.CurrentResult().Outputs["build.version"] != "1.0" {
+		t.Error("child run not found by its id", subPar.subRun.RunId)
+	}
+
+	res := NewFlowLaunchResult(1)
+	res.AddTask("sub")
+	res.AddStatusOrResult(subPar)
+	if len(res.Results["sub"].SubRuns) != 1 {
+		t.Error("child run not added to the results")
+	}
+}
@@ -1,11 +1,15 @@
 package flow
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 )
@@ -15,8 +19,36 @@ const (
 	FAIL
 	WORKING
 	LOOP
+	TIMEOUT   // the task or merge did not complete within its timeout
+	CANCELLED // the flow was stopped while the task was running
+	WAITING   // a gate is waiting for someone to approve it
 )
 
+// the statuses by their lower case names - flow definitions and the api take any case and
+// conditions the upper case names
+var StatusValues = map[string]int{
+	"success":   SUCCESS,
+	"fail":      FAIL,
+	"working":   WORKING,
+	"loop":      LOOP,
+	"timeout":   TIMEOUT,
+	"cancelled": CANCELLED,
+	"waiting":   WAITING,
+}
+
+// the lower case name of the status - or its number if it has no name
+func StatusName(status int) string {
+	for n, s := range StatusValues {
+		if s == status {
+			return n
+		}
+	}
+	return strconv.Itoa(status)
+}
+
+// how long to wait for a task to give up after it has been cancelled or timed out
+const abortGrace = 5 * time.Second
+
 const (
 	KEY_WORKSPACE = "workspace"       // folder for per project files
 	KEY_TIDY_DESK = "reset_workspace" // reset or keep
@@ -26,8 +58,8 @@ const (
 // the interface that the tasknodes hod that actually do the work
 // these task types are added to floe/tasks
 type Task interface {
-	// exec fills in and returns the params
-	Exec(t *TaskNode, p *Params, out *io.PipeWriter)
+	// exec fills in and returns the params - ctx is cancelled if the task should give up
+	Exec(ctx context.Context, t *TaskNode, p *Params, out *io.PipeWriter)
 	Type() string
 }
 
@@ -44,20 +76,26 @@ type TriggeredTaskNode interface {
 	Edges() []Edge
 	SetStream(*io.PipeWriter)
 	SetWorkFlow(*Workflow)
-	SetMergeTrigger()
+	SetMergeTrigger(m *MergeNode)
 }
 
 // task tree structure
 type TaskNode struct {
-	id              string                      // unique id made from the name but should be html friendly
-	name            string                      // unique name within a flow
-	tType           string                      // the type of task that this node has
-	flow            *Workflow                   // this node knows which workflow it is part of
-	C               chan *Params                // the comms/event result channel only triggered when task complete - mergenodes particularly like this
-	do              Task                        // this will be the concrete task to execute
-	Next            map[int][]TriggeredTaskNode // map of tasks by return code
-	usedInMergeNode bool                        // if this is the input to one or more merge nodes
-	CommandStream   *io.PipeWriter              // the passed in stream - only on thread 0 normally
+	id            string                      // unique id made from the name but should be html friendly
+	name          string                      // unique name within a flow
+	tType         string                      // the type of task that this node has
+	flow          *Workflow                   // this node knows which workflow it is part of
+	C             chan *Params                // the comms/event result channel only triggered when task complete - mergenodes particularly like this
+	do            Task                        // this will be the concrete task to execute
+	Next          map[int][]TriggeredTaskNode // map of tasks by return code
+	conds         []condEdge                  // tasks to fire when an expression over the result is true
+	merges        []*MergeNode                // the merge nodes this is an input to
+	CommandStream *io.PipeWriter              // the passed in stream - only on thread 0 normally
+	timeout       time.Duration               // abort the task if it takes longer than this - zero means wait forever
+	retry         *RetryPolicy                // optional policy to re-run failed tasks
+	runLock       sync.Mutex
+	cancelRun     context.CancelFunc // cancels the current execution of the task
+	cancelled     bool               // the current execution was cancelled (by a merge node)
 }
 
 func (tn *TaskNode) SetWorkFlow(f *Workflow) {
@@ -68,8 +106,18 @@ func (tn *TaskNode) WorkFlow() *Workflow {
 	return tn.flow
 }
 
-func (tn *TaskNode) SetMergeTrigger() {
-	tn.usedInMergeNode = true
+func (tn *TaskNode) SetMergeTrigger(m *MergeNode) {
+	tn.merges = append(tn.merges, m)
+}
+
+// stop the task currently executing on this node - e.g. a merge node no longer needs it
+func (tn *TaskNode) Cancel() {
+	tn.runLock.Lock()
+	defer tn.runLock.Unlock()
+	if tn.cancelRun != nil {
+		tn.cancelled = true
+		tn.cancelRun()
+	}
 }
 
 func (tn *TaskNode) DoneChan() chan *Params {
@@ -96,14 +144,29 @@ func (tn *TaskNode) SetStream(cs *io.PipeWriter) {
 	tn.CommandStream = cs
 }
 
+// set the maximum time the task can run for before it is aborted and the node
+// completes with the TIMEOUT status - zero (the default) means no timeout
+func (tn *TaskNode) SetTimeout(d time.Duration) {
+	tn.timeout = d
+}
+
+func (tn *TaskNode) Timeout() time.Duration {
+	return tn.timeout
+}
+
 func (n *TaskNode) Edges() []Edge {
 	edges := make([]Edge, 0, 1)
 	for val, x := range n.Next {
 		for _, xi := range x {
-			edges = append(edges, Edge{Name: fmt.Sprintf("%v", val), From: n.Id(), To: xi.Id()})
+			edges = append(edges, Edge{Name: fmt.Sprintf("%v", val), Status: val, From: n.Id(), To: xi.Id()})
 		}
 	}
 
+	// conditional edges are labelled with their expression
+	for _, c := range n.conds {
+		edges = append(edges, Edge{Name: c.cond.String(), Conditional: true, From: n.Id(), To: c.to.Id()})
+	}
+
 	return edges
 }
 
@@ -150,8 +213,56 @@ func (tn *TaskNode) AddNext(forStatus int, t TriggeredTaskNode) error {
 	return nil
 }
 
+type condEdge struct {
+	cond *Condition
+	to   TriggeredTaskNode
+}
+
+// add a node to fire when the expression is true for the result of this task, whatever its status
+// - see Condition for the syntax. These fire as well as any status nodes that match.
+func (tn *TaskNode) AddNextIf(expr string, t TriggeredTaskNode) error {
+	cond, err := ParseCondition(expr)
+	if err != nil {
+		glog.Error(err)
+		return err
+	}
+
+	if tn.do == nil {
+		es := "can't add next nodes if current task not set"
+		glog.Error(es)
+		return errors.New(es)
+	}
+
+	if tn.flow == nil {
+		es := "can't add next nodes if current flow not set"
+		glog.Error(es)
+		return errors.New(es)
+	}
+
+	if t.WorkFlow() != tn.flow {
+		panic("next nodes have to be in the same workflow")
+	}
+
+	tn.flow.registerNode(t)
+	tn.conds = append(tn.conds, condEdge{cond: cond, to: t})
+
+	return nil
+}
+
+// the nodes to fire for this result
+func (tn *TaskNode) nextFor(p *Params) []TriggeredTaskNode {
+	next := append([]TriggeredTaskNode{}, tn.Next[p.Status]...)
+	for _, c := range tn.conds {
+		if c.cond.Eval(p) {
+			glog.Infof("condition %q true for %s", c.cond, tn.Id())
+			next = append(next, c.to)
+		}
+	}
+	return next
+}
+
 func (tn *TaskNode) Exec(inPar *Params) {
-	glog.Info("exec ", tn.Name)
+	glog.Info("exec ", tn.Name())
 	if tn.do != nil {
 		// copy the parameters now as these will be the status update
 		curPar := MakeParams()
@@ -165,36 +276,51 @@ func (tn *TaskNode) Exec(inPar *Params) {
 		curPar.TaskName = tn.Name()
 		curPar.TaskId = tn.Id()
 
-		// wait for stepper trigger
-		<-tn.flow.Stepper
+		// wait for stepper trigger (or the debugger) - unless the flow was stopped in the meantime
+		if !tn.flow.waitStep(curPar) {
+			curPar.Status = CANCELLED
+			curPar.Response = "flow stopped"
+			curPar.Complete = true
+			tn.fireCancelled(curPar)
+			return
+		}
 
 		glog.Info("====== Executing >>>>>>>> ", curPar.TaskName, " ", curPar.TaskId, " ", curPar.ThreadId)
+		started := time.Now()
+		curPar.Started = started
 
 		// send a not completed signal to mark the start - must copy because reciever may only get the
 		// par after the task has finished and marked it complete
 		startPar := MakeParams()
 		startPar.Copy(curPar)
 		startPar.Complete = false
-		tn.flow.C <- startPar
+		startPar.Started = curPar.Started
+		tn.flow.send(startPar)
 
 		// log out the curPar object
 		b, _ := json.MarshalIndent(curPar, "", "  ")
 		glog.Info(string(b))
 
-		// actually execute the task
-		tn.do.Exec(tn, curPar, tn.CommandStream)
+		// actually execute the task - retrying if there is a policy for it
+		curPar = tn.execAttempts(curPar)
+
+		// retried and abandoned tasks give back a copy of the params - which does not keep the start
+		curPar.Started = started
+		curPar.Ended = time.Now()
 
 		glog.Info("===== Done <<<< ", curPar.TaskId, " ", curPar.Status, " ", curPar.ExitStatus, " ", curPar.ThreadId)
 
-		// TODO - consider adding all results to the props - for use in later tasks
+		// make the results available to later tasks
+		curPar.publishOutputs(tn.Id())
 
 		// Exec on the task types must be synchronous
 		curPar.Complete = true
 
-		// check for the Stop all flag - cos chanel might be closed
-		if tn.flow.Stop {
+		// check if the flow was stopped - cos chanel might be closed
+		if tn.flow.Cancelled() {
 			glog.Warning("thread stopped")
-			tn.flow.End.FireDoneChan(curPar)
+			curPar.Status = CANCELLED
+			tn.fireCancelled(curPar)
 			return
 		}
 
@@ -209,7 +335,7 @@ func (tn *TaskNode) Exec(inPar *Params) {
 		}
 
 		// fire message to the flow notification channel
-		tn.flow.C <- curPar
+		tn.flow.send(curPar)
 
 		if curPar == nil {
 			panic("Return parameters cant be nil - at least return the passed in parameters")
@@ -223,18 +349,23 @@ func (tn *TaskNode) Exec(inPar *Params) {
 			tn.C <- curPar
 		}
 
+		// let any merge nodes know we are done
+		for _, m := range tn.merges {
+			m.arrive(tn.Id(), curPar)
+		}
+
 		glog.Info("return staus = ", curPar.Status)
-		next, ok := tn.Next[curPar.Status]
+		next := tn.nextFor(curPar)
 
-		// if we have another task that matches this return code execute it
-		if ok {
+		// if we have another task that matches this return code or a true condition execute it
+		if len(next) > 0 {
 			glog.Infof("found %d next task(s)", len(next))
 			for _, n := range next {
 				go n.Exec(curPar) // launch the next one with the results of this one (TODO - results of all props past?)
 			}
 		} else {
 			// otherwise trigger the last tasks channel - as that is the signal that this thread has finished
-			if !tn.usedInMergeNode && tn != tn.flow.End {
+			if len(tn.merges) == 0 && tn != tn.flow.End {
 				glog.Warning("problem - dead end task - this workflow may not of ended properly")
 				tn.flow.End.FireDoneChan(curPar)
 			}
@@ -245,6 +376,85 @@ func (tn *TaskNode) Exec(inPar *Params) {
 	}
 }
 
+// the flow was stopped - end the thread, without blocking as more than one branch may be stopping
+func (tn *TaskNode) fireCancelled(p *Params) {
+	select {
+	case tn.flow.End.DoneChan() <- p:
+	default:
+	}
+}
+
+// run the task with the flows context - if the node has a timeout and the task does not finish in time
+// then the task is cancelled and completes with the TIMEOUT status, if the whole flow was stopped the
+// status is CANCELLED
+func (tn *TaskNode) execTask(curPar *Params) *Params {
+	ctx, cancel := context.WithCancel(tn.flow.Context())
+	defer cancel()
+
+	tn.runLock.Lock()
+	tn.cancelRun = cancel
+	tn.cancelled = false
+	tn.runLock.Unlock()
+
+	if tn.timeout > 0 {
+		var tCancel context.CancelFunc
+		ctx, tCancel = context.WithTimeout(ctx, tn.timeout)
+		defer tCancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		tn.do.Exec(ctx, tn, curPar, tn.CommandStream)
+		close(done)
+	}()
+
+	finished := true
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// give the task a chance to clean up
+		select {
+		case <-done:
+		case <-time.After(abortGrace):
+			glog.Error("task did not stop when asked ", tn.Id())
+			finished = false
+		}
+	}
+
+	if ctx.Err() == nil {
+		return curPar
+	}
+
+	// the task may still be writing to curPar so give back a fresh one
+	resPar := curPar
+	if !finished {
+		resPar = MakeParams()
+		resPar.Copy(curPar)
+	}
+
+	tn.runLock.Lock()
+	nodeCancelled := tn.cancelled
+	tn.runLock.Unlock()
+
+	if tn.flow.Cancelled() {
+		resPar.Status = CANCELLED
+		resPar.Response = "flow stopped"
+	} else if nodeCancelled {
+		resPar.Status = CANCELLED
+		resPar.Response = "task no longer needed"
+	} else {
+		glog.Warning("task timed out ", tn.Id(), " after ", tn.timeout)
+		resPar.Status = TIMEOUT
+		resPar.Response = fmt.Sprintf("task timed out after %v", tn.timeout)
+	}
+
+	if tn.CommandStream != nil {
+		tn.CommandStream.Write([]byte(resPar.Response + "\n"))
+	}
+
+	return resPar
+}
+
 // TODO - make html friendly id
 func MakeID(name string) string {
 
@@ -261,7 +471,9 @@ type Node struct {
 
 // struct for reporting e.g. for json-ifying
 type Edge struct {
-	Name string
-	From string
-	To   string
+	Name        string
+	From        string
+	To          string
+	Status      int  // the status the edge is taken on - unless it is conditional
+	Conditional bool // taken when the condition in the name is true rather than on a status
 }
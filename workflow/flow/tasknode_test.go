@@ -0,0 +1,168 @@
+package flow
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+type nopTask struct{}
+
+func (t nopTask) Type() string { return "nop" }
+
+func (t nopTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	p.Status = SUCCESS
+}
+
+// run the flow stepping automatically until the end node fires - returns all the completed statuses
+func runTestFlow(t *testing.T, w *Workflow, e TriggeredTaskNode) ([]*Params, *Params) {
+	if issues := w.Validate(false); issues.HasErrors() {
+		t.Fatal(issues)
+	}
+
+	statuses := []*Params{}
+	done := make(chan bool)
+	go func() {
+		for p := range w.C {
+			if p.Complete {
+				statuses = append(statuses, p)
+			}
+		}
+		close(done)
+	}()
+	go func() {
+		for {
+			select {
+			case w.Stepper <- 1:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	p := MakeParams()
+	p.FlowName = w.Name
+	w.Exec(p)
+
+	select {
+	case end := <-e.DoneChan():
+		close(w.C)
+		<-done
+		return statuses, end
+	case <-time.After(2 * time.Second):
+		t.Fatal("flow did not finish")
+	}
+	return nil, nil
+}
+
+// waits until its context is done and says why
+type waitTask struct {
+	stopped chan error
+}
+
+func (t *waitTask) Type() string { return "wait" }
+
+func (t *waitTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	<-ctx.Done()
+	t.stopped <- ctx.Err()
+	p.Status = SUCCESS
+}
+
+func Test_TaskTimeout(t *testing.T) {
+	wt := &waitTask{stopped: make(chan error, 1)}
+
+	w := MakeWorkflow()
+	w.Name = "timeout"
+	s := w.MakeTaskNode("s", wt)
+	s.SetTimeout(20 * time.Millisecond)
+	ok := w.MakeTaskNode("ok", nopTask{})
+	late := w.MakeTaskNode("late", nopTask{})
+	s.AddNext(SUCCESS, ok)
+	s.AddNext(TIMEOUT, late)
+	w.SetStart(s)
+	w.SetEnd(late)
+
+	statuses, end := runTestFlow(t, w, late)
+
+	// the task was told to stop when its time was up
+	select {
+	case err := <-wt.stopped:
+		if err != context.DeadlineExceeded {
+			t.Error("task context not timed out", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("task context not cancelled")
+	}
+
+	timedOut := false
+	for _, p := range statuses {
+		if p.TaskId == "s" {
+			timedOut = p.Status == TIMEOUT
+		}
+		if p.TaskId == "ok" {
+			t.Error("the success edge should not be followed")
+		}
+	}
+	if !timedOut {
+		t.Error("expected the node to complete with TIMEOUT", statuses)
+	}
+	if end.TaskId != "late" {
+		t.Error("expected the TIMEOUT edge to be followed", end.TaskId)
+	}
+}
+
+// outputs v and keeps the props it was run with
+type outTask struct {
+	v    string
+	seen Props
+}
+
+func (t *outTask) Type() string { return "out" }
+
+func (t *outTask) Exec(ctx context.Context, tn *TaskNode, p *Params, out *io.PipeWriter) {
+	t.seen = Props{}
+	for k, v := range p.Props {
+		t.seen[k] = v
+	}
+	p.SetOutput("v", t.v)
+	p.Status = SUCCESS
+}
+
+func Test_TaskOutputs(t *testing.T) {
+	at, bt, ct := &outTask{v: "1"}, &outTask{v: "2"}, &outTask{v: "3"}
+
+	w := MakeWorkflow()
+	w.Name = "outputs"
+	a := w.MakeTaskNode("a", at)
+	b := w.MakeTaskNode("b", bt)
+	c := w.MakeTaskNode("c", ct)
+	a.AddNext(SUCCESS, b)
+	b.AddNext(SUCCESS, c)
+	w.SetStart(a)
+	w.SetEnd(c)
+
+	_, end := runTestFlow(t, w, c)
+
+	// each node sees the outputs of the nodes before it under their ids
+	if bt.seen["a.v"] != "1" || bt.seen["v"] != "" {
+		t.Error("b did not get the namespaced output of a", bt.seen)
+	}
+	if ct.seen["a.v"] != "1" || ct.seen["b.v"] != "2" {
+		t.Error("the output of b overwrote the output of a", ct.seen)
+	}
+	if end.Props["a.v"] != "1" || end.Props["b.v"] != "2" || end.Props["c.v"] != "3" {
+		t.Error("outputs not all published", end.Props)
+	}
+}
+
+func Test_StatusNames(t *testing.T) {
+	for name, st := range StatusValues {
+		if StatusName(st) != name {
+			t.Error("status ", st, " named ", StatusName(st), " not ", name)
+		}
+	}
+	if StatusName(42) != "42" {
+		t.Error("a status without a name should be its number", StatusName(42))
+	}
+}
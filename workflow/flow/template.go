@@ -0,0 +1,137 @@
+package flow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// variables that are not props but can still be used in task arguments
+const (
+	VAR_THREAD_ID = "thread-id"
+	VAR_FLOW_NAME = "flow-name"
+	VAR_TASK_ID   = "task-id"
+)
+
+// Expand replaces ${name} in s with the value of the named prop - which includes the workspace and
+// any upstream outputs as <task-id>.<key> - or one of the VAR_ variables. Use $${ for a literal ${
+// An error listing all of them is returned if any variables are not defined.
+func (p *Params) Expand(s string) (string, error) {
+	return p.expand(s, false)
+}
+
+// ExpandShell is like Expand but every substituted value is quoted so it is passed to the shell
+// as a single word - whatever it contains. A value inside a quoted part of s is escaped to suit the
+// quotes it is in rather than quoted again. Undefined names are an error here too so a typo does not
+// run the command with an empty shell variable - use $${HOME} to leave ${HOME} for the shell.
+func (p *Params) ExpandShell(s string) (string, error) {
+	return p.expand(s, true)
+}
+
+func (p *Params) lookup(name string) (string, bool) {
+	switch name {
+	case VAR_THREAD_ID:
+		return strconv.Itoa(p.ThreadId), true
+	case VAR_FLOW_NAME:
+		return p.FlowName, true
+	case VAR_TASK_ID:
+		return p.TaskId, true
+	}
+	v, ok := p.Props[name]
+	return v, ok
+}
+
+func (p *Params) expand(s string, quote bool) (string, error) {
+	var out strings.Builder
+	undefined := []string{}
+	quotes := noQuote // where the text written so far leaves the shell
+
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			out.WriteString(s)
+			break
+		}
+
+		// escaped
+		if i > 0 && s[i-1] == '$' {
+			out.WriteString(s[:i-1] + "${")
+			quotes = quotes.scan(s[:i-1])
+			s = s[i+2:]
+			continue
+		}
+
+		out.WriteString(s[:i])
+		quotes = quotes.scan(s[:i])
+		s = s[i+2:]
+
+		j := strings.Index(s, "}")
+		if j < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		name := strings.TrimSpace(s[:j])
+		s = s[j+1:]
+
+		v, ok := p.lookup(name)
+		if !ok {
+			undefined = append(undefined, "${"+name+"}")
+			continue
+		}
+
+		if quote {
+			v = quotes.quote(v)
+		}
+		out.WriteString(v)
+	}
+
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("undefined variables: %s", strings.Join(undefined, ", "))
+	}
+
+	return out.String(), nil
+}
+
+// ShellQuote wraps s in single quotes so the shell treats it as one literal word
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// the kind of quotes the shell is inside of
+type shellQuotes int
+
+const (
+	noQuote shellQuotes = iota
+	singleQuote
+	doubleQuote
+)
+
+// the quotes the shell is in after reading s
+func (q shellQuotes) scan(s string) shellQuotes {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && q != singleQuote:
+			i++ // the next char is escaped
+		case c == '\'' && q == noQuote:
+			q = singleQuote
+		case c == '\'' && q == singleQuote:
+			q = noQuote
+		case c == '"' && q == noQuote:
+			q = doubleQuote
+		case c == '"' && q == doubleQuote:
+			q = noQuote
+		}
+	}
+	return q
+}
+
+// make v a literal inside these quotes
+func (q shellQuotes) quote(v string) string {
+	switch q {
+	case singleQuote:
+		return strings.Replace(v, "'", `'\''`, -1)
+	case doubleQuote:
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+		return r.Replace(v)
+	}
+	return ShellQuote(v)
+}
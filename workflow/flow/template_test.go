@@ -0,0 +1,63 @@
+package flow
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Expand(t *testing.T) {
+	p := MakeParams()
+	p.ThreadId = 3
+	p.Props["git-trigger-hash"] = "abc123"
+	p.Props[OutputKey("build", "version")] = "1.2"
+	p.Props["evil"] = "x'; rm -rf / #"
+
+	s, err := p.Expand("checkout ${git-trigger-hash} in ${workspace} on ${thread-id} v${build.version} $${HOME}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "checkout abc123 in workspace on 3 v1.2 ${HOME}" {
+		t.Error("bad expansion", s)
+	}
+
+	s, err = p.ExpandShell("echo ${evil}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != `echo 'x'\''; rm -rf / #'` {
+		t.Error("bad quoting", s)
+	}
+
+	// inside quotes the value is escaped for them rather than quoted again
+	p.Props["name"] = `it's "$x"`
+	for in, want := range map[string]string{
+		`echo "hi ${name}"`:    `echo "hi it's \"\$x\""`,
+		`echo 'hi ${name}'`:    `echo 'hi it'\''s "$x"'`,
+		`echo "a" ${name} 'b'`: `echo "a" 'it'\''s "$x"' 'b'`,
+		`echo \" ${name}`:      `echo \" 'it'\''s "$x"'`,
+	} {
+		if s, err := p.ExpandShell(in); err != nil || s != want {
+			t.Error("bad quoting of ", in, " got ", s, err)
+		}
+	}
+
+	// the shell only gets the names that are escaped
+	s, err = p.ExpandShell(`cd $${HOME} && $${PATH_TO}/run "${name}"`)
+	if err != nil || s != `cd ${HOME} && ${PATH_TO}/run "it's \"\$x\""` {
+		t.Error("escaped names not left for the shell", s, err)
+	}
+	_, err = p.ExpandShell(`git checkout ${build.hash}`)
+	if err == nil || !strings.Contains(err.Error(), "${build.hash}") {
+		t.Error("expected the undefined variable in the error", err)
+	}
+
+	_, err = p.Expand("${nope} and ${nada}")
+	if err == nil || !strings.Contains(err.Error(), "${nope}") || !strings.Contains(err.Error(), "${nada}") {
+		t.Error("expected both undefined variables in the error", err)
+	}
+
+	_, err = p.Expand("${oops")
+	if err == nil {
+		t.Error("expected an unterminated error")
+	}
+}
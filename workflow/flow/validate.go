@@ -0,0 +1,332 @@
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ISSUE_ERROR   = "error"   // the flow can not be run
+	ISSUE_WARNING = "warning" // the flow can run but probably not as intended
+)
+
+// a problem found with the structure of a workflow
+type ValidationIssue struct {
+	Level   string
+	Flow    string
+	Node    string
+	Message string
+}
+
+func (vi ValidationIssue) String() string {
+	if vi.Node == "" {
+		return fmt.Sprintf("%s: %s: %s", vi.Level, vi.Flow, vi.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", vi.Level, vi.Flow, vi.Node, vi.Message)
+}
+
+type ValidationIssues []ValidationIssue
+
+func (vis ValidationIssues) HasErrors() bool {
+	for _, vi := range vis {
+		if vi.Level == ISSUE_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+// all the errors as a single message
+func (vis ValidationIssues) Error() string {
+	msgs := []string{}
+	for _, vi := range vis {
+		if vi.Level == ISSUE_ERROR {
+			msgs = append(msgs, vi.String())
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// check the graph of nodes without running it - isTrigger flows start from their trigger nodes
+// rather than the Start node
+func (w *Workflow) Validate(isTrigger bool) ValidationIssues {
+	v := &validator{
+		w:   w,
+		out: map[string][]Edge{},
+	}
+	v.run(isTrigger)
+	return v.issues
+}
+
+type validator struct {
+	w      *Workflow
+	out    map[string][]Edge // outbound edges by node id
+	issues ValidationIssues
+}
+
+func (v *validator) add(level, node, format string, args ...interface{}) {
+	v.issues = append(v.issues, ValidationIssue{
+		Level:   level,
+		Flow:    v.w.Name,
+		Node:    node,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) run(isTrigger bool) {
+	w := v.w
+
+	if w.buildErr != nil {
+		v.add(ISSUE_ERROR, "", "could not be made: %v", w.buildErr)
+	}
+
+	for _, id := range w.duplicates {
+		v.add(ISSUE_ERROR, id, "two different nodes have the same id")
+	}
+
+	if w.End == nil {
+		v.add(ISSUE_ERROR, "", "has no end node")
+	}
+
+	roots := []string{}
+	if isTrigger {
+		for _, id := range v.nodeIds() {
+			if w.TaskNodes[id].Type() == "trigger" {
+				roots = append(roots, id)
+			}
+		}
+		if len(roots) == 0 {
+			v.add(ISSUE_ERROR, "", "trigger flow has no trigger nodes")
+		}
+	} else if w.Start == nil {
+		v.add(ISSUE_ERROR, "", "has no start node")
+	} else {
+		roots = append(roots, w.Start.Id())
+	}
+
+	// gather all the edges by the node they leave
+	for _, id := range v.nodeIds() {
+		for _, e := range w.TaskNodes[id].Edges() {
+			if _, ok := w.TaskNodes[e.To]; !ok {
+				v.add(ISSUE_ERROR, e.From, "edge to unregistered node %s", e.To)
+				continue
+			}
+			v.out[e.From] = append(v.out[e.From], e)
+		}
+	}
+	for _, edges := range v.out {
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].To == edges[j].To {
+				return edges[i].Name < edges[j].Name
+			}
+			return edges[i].To < edges[j].To
+		})
+	}
+
+	reached := v.reach(roots, "")
+
+	for _, id := range v.nodeIds() {
+		n := w.TaskNodes[id]
+		if !reached[id] && len(roots) > 0 {
+			v.add(ISSUE_WARNING, id, "can not be reached from the start of the flow")
+		}
+
+		switch tn := n.(type) {
+		case *TaskNode:
+			if len(v.out[id]) == 0 && len(tn.merges) == 0 && n != w.End {
+				v.add(ISSUE_WARNING, id, "dead end task - the flow will end here")
+			}
+		case *MergeNode:
+			v.checkMerge(tn, roots)
+		case *LoopNode:
+			v.checkLoop(tn)
+		case *ForEachNode:
+			for _, vi := range tn.template.Validate(false) {
+				v.add(vi.Level, tn.bodyId(vi.Node), "in the body: %s", vi.Message)
+			}
+		}
+	}
+
+	if w.End != nil && len(roots) > 0 && !reached[w.End.Id()] {
+		v.add(ISSUE_ERROR, w.End.Id(), "the end node can not be reached")
+	}
+
+	v.checkCycles()
+}
+
+// node ids in a stable order so the issues come out the same each time
+func (v *validator) nodeIds() []string {
+	ids := make([]string, 0, len(v.w.TaskNodes))
+	for id := range v.w.TaskNodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// all nodes that can be reached from the roots - without passing through the avoid node
+func (v *validator) reach(roots []string, avoid string) map[string]bool {
+	seen := map[string]bool{}
+	todo := append([]string{}, roots...)
+	for len(todo) > 0 {
+		id := todo[0]
+		todo = todo[1:]
+		if seen[id] || id == avoid {
+			continue
+		}
+		seen[id] = true
+		for _, e := range v.out[id] {
+			todo = append(todo, e.To)
+		}
+	}
+	return seen
+}
+
+// a merge only fires if enough of its triggers can fire before it does
+func (v *validator) checkMerge(mn *MergeNode, roots []string) {
+	if len(mn.Triggers) == 0 {
+		v.add(ISSUE_ERROR, mn.Id(), "merge node has no triggers")
+		return
+	}
+
+	if mn.join.Mode == JOIN_N && (mn.join.N < 1 || mn.join.N > len(mn.Triggers)) {
+		v.add(ISSUE_WARNING, mn.Id(), "waits for %d of %d triggers", mn.join.N, len(mn.Triggers))
+	}
+
+	if len(roots) == 0 {
+		return
+	}
+
+	before := v.reach(roots, mn.Id())
+	can := 0
+	for _, t := range mn.Triggers {
+		if before[t.Id()] {
+			can++
+		} else {
+			v.add(ISSUE_WARNING, mn.Id(), "trigger %s can never fire before the merge", t.Id())
+		}
+	}
+
+	if can < mn.needed() {
+		v.add(ISSUE_ERROR, mn.Id(), "only %d of the %d triggers it needs can fire", can, mn.needed())
+	}
+}
+
+// a loop needs a body that comes back round to it
+func (v *validator) checkLoop(ln *LoopNode) {
+	if ln.Body == nil {
+		v.add(ISSUE_ERROR, ln.Id(), "loop node has no body")
+		return
+	}
+
+	back := false
+	for id := range v.reach([]string{ln.Body.Id()}, "") {
+		for _, e := range v.out[id] {
+			if e.To == ln.Id() {
+				back = true
+			}
+		}
+	}
+	if !back {
+		v.add(ISSUE_WARNING, ln.Id(), "the loop body never returns to the loop")
+	}
+
+	s := ln.spec
+	if s.Count == 0 && !s.UntilSuccess && s.While == "" {
+		v.add(ISSUE_WARNING, ln.Id(), "loop has no count, while or until success so will not run the body")
+	}
+	if s.UntilSuccess && s.Max <= 0 {
+		v.add(ISSUE_ERROR, ln.Id(), "until success loop needs a max or a body that never succeeds loops forever")
+	}
+}
+
+// a cycle in the graph must have a LOOP edge in it or it may never end - a cycle with a condition in
+// it or that can be left on another status only gets a warning as it ends if the flow takes that way
+func (v *validator) checkCycles() {
+	// depth first search - any edge back to a node on the current path closes a cycle
+	const (
+		unseen = iota
+		onPath
+		done
+	)
+	state := map[string]int{}
+	path := []Edge{}
+
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = onPath
+		for _, e := range v.out[id] {
+			switch state[e.To] {
+			case unseen:
+				path = append(path, e)
+				visit(e.To)
+				path = path[:len(path)-1]
+			case onPath:
+				// walk back along the path to the start of the cycle
+				cycle := []Edge{e}
+				for i := len(path) - 1; i >= 0; i-- {
+					cycle = append(cycle, path[i])
+					if path[i].From == e.To {
+						break
+					}
+				}
+				switch {
+				case hasStatusEdge(cycle, LOOP):
+				case v.canLeave(cycle):
+					v.add(ISSUE_WARNING, e.From, "cycle back to %s without a LOOP edge - it only ends if a condition or status takes the flow out of it", e.To)
+				default:
+					v.add(ISSUE_ERROR, e.From, "cycle back to %s without a LOOP edge", e.To)
+				}
+			}
+		}
+		state[id] = done
+	}
+
+	for _, id := range v.nodeIds() {
+		if state[id] == unseen {
+			visit(id)
+		}
+	}
+}
+
+func hasStatusEdge(edges []Edge, status int) bool {
+	for _, e := range edges {
+		if !e.Conditional && e.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// can the flow get out of the cycle - it has a conditional edge in it, or a node in it has an edge
+// out of the cycle for another status than the one that keeps it going round
+func (v *validator) canLeave(cycle []Edge) bool {
+	on := map[string]int{} // the status that keeps the cycle going by node
+	for _, e := range cycle {
+		if e.Conditional {
+			return true
+		}
+		on[e.From] = e.Status
+	}
+	for id, status := range on {
+		for _, e := range v.out[id] {
+			_, in := on[e.To]
+			if !e.Conditional && !in && e.Status != status {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validate every flow in the project - by flow id, flows without issues are left out
+func (p *Project) Validate() map[string]ValidationIssues {
+	res := map[string]ValidationIssues{}
+	for id, fl := range p.FlowLaunchers {
+		if issues := fl.Validate(); len(issues) > 0 {
+			res[id] = issues
+		}
+	}
+	return res
+}
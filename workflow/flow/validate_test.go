@@ -0,0 +1,175 @@
+package flow
+
+import (
+	"testing"
+)
+
+func hasIssue(issues ValidationIssues, level, node string) bool {
+	for _, vi := range issues {
+		if vi.Level == level && vi.Node == node {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_ValidateGoodFlow(t *testing.T) {
+	w := MakeWorkflow()
+	w.Name = "good"
+	a := w.MakeTaskNode("a", nopTask{})
+	b := w.MakeTaskNode("b", nopTask{})
+	c := w.MakeTaskNode("c", nopTask{})
+	m := w.MakeMergeNode("m")
+	a.AddNext(SUCCESS, b)
+	a.AddNext(SUCCESS, c)
+	m.AddTrigger(b)
+	m.AddTrigger(c)
+	w.SetStart(a)
+	w.SetEnd(m)
+
+	issues := w.Validate(false)
+	if len(issues) != 0 {
+		t.Error("expected no issues", issues)
+	}
+}
+
+func Test_ValidateBadFlow(t *testing.T) {
+	w := MakeWorkflow()
+	w.Name = "bad"
+	a := w.MakeTaskNode("a", nopTask{})
+	b := w.MakeTaskNode("b", nopTask{})
+	dead := w.MakeTaskNode("dead", nopTask{})
+	lost := w.MakeTaskNode("lost", nopTask{})
+	end := w.MakeTaskNode("end", nopTask{})
+	m := w.MakeMergeNode("m")
+	w.MakeTaskNode("a", nopTask{}) // duplicate
+
+	a.AddNext(SUCCESS, b)
+	a.AddNext(FAIL, dead)
+	b.AddNext(SUCCESS, a) // cycle with no loop - but a leaves it on FAIL
+	m.AddTrigger(lost)
+	w.SetStart(a)
+	w.SetEnd(end)
+
+	issues := w.Validate(false)
+
+	if !issues.HasErrors() {
+		t.Fatal("expected errors")
+	}
+
+	expect := []struct{ level, node string }{
+		{ISSUE_ERROR, "a"},
+		{ISSUE_WARNING, "dead"},
+		{ISSUE_WARNING, "lost"},
+		{ISSUE_ERROR, "m"},
+		{ISSUE_ERROR, "end"},
+		{ISSUE_WARNING, "b"},
+	}
+
+	for _, e := range expect {
+		if !hasIssue(issues, e.level, e.node) {
+			t.Error("missing", e.level, "for", e.node, issues)
+		}
+	}
+}
+
+func Test_ValidateLoopCycle(t *testing.T) {
+	w := MakeWorkflow()
+	w.Name = "loop"
+	a := w.MakeTaskNode("a", nopTask{})
+	b := w.MakeTaskNode("b", nopTask{})
+	c := w.MakeTaskNode("c", nopTask{})
+	a.AddNext(LOOP, b)
+	a.AddNext(SUCCESS, c)
+	b.AddNext(SUCCESS, a)
+	w.SetStart(a)
+	w.SetEnd(c)
+
+	issues := w.Validate(false)
+	if issues.HasErrors() {
+		t.Error("a cycle through a loop edge is fine", issues)
+	}
+}
+
+func Test_ValidateCycleExits(t *testing.T) {
+	cycle := func(exit func(a, b, c *TaskNode)) ValidationIssues {
+		w := MakeWorkflow()
+		w.Name = "cycle"
+		s := w.MakeTaskNode("s", nopTask{})
+		a := w.MakeTaskNode("a", nopTask{})
+		b := w.MakeTaskNode("b", nopTask{})
+		c := w.MakeTaskNode("c", nopTask{})
+		s.AddNext(SUCCESS, a)
+		a.AddNext(SUCCESS, b)
+		exit(a, b, c)
+		w.SetStart(s)
+		w.SetEnd(c)
+		return w.Validate(false)
+	}
+
+	// round and round on success with nothing that stops it
+	issues := cycle(func(a, b, c *TaskNode) {
+		b.AddNext(SUCCESS, a)
+		b.AddNext(SUCCESS, c)
+	})
+	if !hasIssue(issues, ISSUE_ERROR, "b") {
+		t.Error("expected a cycle with no way out to be an error", issues)
+	}
+
+	// a condition out of the cycle fires as well as the edge back - so it does not stop it
+	issues = cycle(func(a, b, c *TaskNode) {
+		b.AddNext(SUCCESS, a)
+		b.AddNextIf("Status == FAIL", c)
+	})
+	if !hasIssue(issues, ISSUE_ERROR, "b") {
+		t.Error("expected a condition beside the cycle to still be an error", issues)
+	}
+
+	// back round on fail - out on success
+	issues = cycle(func(a, b, c *TaskNode) {
+		b.AddNext(FAIL, a)
+		b.AddNext(SUCCESS, c)
+	})
+	if issues.HasErrors() || !hasIssue(issues, ISSUE_WARNING, "b") {
+		t.Error("expected a cycle with a status exit to be a warning", issues)
+	}
+
+	// back round only while a condition holds
+	issues = cycle(func(a, b, c *TaskNode) {
+		b.AddNextIf("count < 3", a)
+		b.AddNext(SUCCESS, c)
+	})
+	if issues.HasErrors() || !hasIssue(issues, ISSUE_WARNING, "b") {
+		t.Error("expected a conditional cycle to be a warning", issues)
+	}
+
+	// a condition that reads like a status is still a condition - not a loop edge
+	issues = cycle(func(a, b, c *TaskNode) {
+		b.AddNextIf("3", a)
+		b.AddNextIf("1", c)
+	})
+	if issues.HasErrors() || !hasIssue(issues, ISSUE_WARNING, "b") {
+		t.Error("expected a numeric condition to be treated as a condition", issues)
+	}
+}
+
+func Test_ValidateUntilSuccessMax(t *testing.T) {
+	for max, bad := range map[int]bool{0: true, -1: true, 3: false} {
+		w := MakeWorkflow()
+		w.Name = "until"
+		s := w.MakeTaskNode("s", nopTask{})
+		l, _ := w.MakeLoopNode("l", LoopSpec{UntilSuccess: true, Max: max})
+		b := w.MakeTaskNode("b", nopTask{})
+		e := w.MakeTaskNode("e", nopTask{})
+		s.AddNext(SUCCESS, l)
+		l.AddNext(LOOP, b)
+		b.AddNext(SUCCESS, l)
+		b.AddNext(FAIL, l)
+		l.AddNext(SUCCESS, e)
+		w.SetStart(s)
+		w.SetEnd(e)
+		if got := hasIssue(w.Validate(false), ISSUE_ERROR, "l"); got != bad {
+			t.Error("max ", max, " gave an error ", got)
+		}
+	}
+}
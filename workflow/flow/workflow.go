@@ -1,6 +1,10 @@
 package flow
 
 import (
+	"context"
+	"errors"
+	"io"
+
 	"github.com/golang/glog"
 )
 
@@ -15,16 +19,68 @@ type Workflow struct {
 	C              chan *Params                 // the chanel that tasknodes attach to and send status updates
 	Stepper        chan int                     // inbound channel - to provide stepping
 	TaskNodes      map[string]TriggeredTaskNode // map by name of all our nodes
-	Stop           bool                         // set true to stop this threads flow - or to mark it stopped
 	IgnoreTriggers bool                         // set by the first trigger in the flow - stops other triggers from firing
+	ctx            context.Context              // cancelled to stop this threads flow - all tasks are run with this
+	duplicates     []string                     // ids that more than one node was registered with - see Validate
+	buildErr       error                        // why the flow could not be made - see Validate
+	debugger       *Debugger                    // if set nodes wait for the debugger rather than the stepper
+	runId          string                       // the run this thread is part of
+	quit           chan struct{}                // closed when nothing reads C any more - the body of a for each item that has ended
+	cancel         context.CancelFunc
 }
 
 func MakeWorkflow() *Workflow {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Workflow{
 		C:              make(chan *Params),
 		Stepper:        make(chan int),
 		TaskNodes:      make(map[string]TriggeredTaskNode),
 		IgnoreTriggers: false,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// a flow that could not be made - it fails validation with the error so it never runs
+func MakeBrokenWorkflow(err error) *Workflow {
+	w := MakeWorkflow()
+	w.buildErr = err
+	return w
+}
+
+// the context all tasks in this flow are executed with
+func (w *Workflow) Context() context.Context {
+	return w.ctx
+}
+
+// stop this threads flow - any running tasks are told to give up
+func (w *Workflow) Cancel() {
+	w.cancel()
+}
+
+// has this threads flow been stopped
+func (w *Workflow) Cancelled() bool {
+	return w.ctx.Err() != nil
+}
+
+// wait until the node with these params may execute - returns false if the flow was stopped first
+// send the status to whatever is reading C - dropped if nothing reads it any more
+func (w *Workflow) send(p *Params) {
+	select {
+	case w.C <- p:
+	case <-w.quit:
+	}
+}
+
+func (w *Workflow) waitStep(p *Params) bool {
+	if w.debugger != nil {
+		return w.debugger.wait(w.ctx, p)
+	}
+	select {
+	case <-w.Stepper:
+		return true
+	case <-w.ctx.Done():
+		return false
 	}
 }
 
@@ -33,9 +89,11 @@ func (w *Workflow) registerNode(tn TriggeredTaskNode) {
 	node, in := w.TaskNodes[tn.Id()]
 
 	if in {
-		// fail loud if they are not actually the same node being registered more than once!
+		// remember if they are not actually the same node being registered more than once - Validate will fail it
 		if node != tn {
-			panic("two nodes with the same id " + tn.Id())
+			glog.Error("two nodes with the same id ", tn.Id())
+			w.duplicates = append(w.duplicates, tn.Id())
+			return
 		}
 	}
 
@@ -57,16 +115,94 @@ func (w *Workflow) MakeTaskNode(name string, t Task) *TaskNode {
 
 func (w *Workflow) MakeMergeNode(name string) *MergeNode {
 	mn := &MergeNode{
-		id:    MakeID(name),
-		name:  name,
-		first: true,
-		C:     make(chan *Params, 1), // a buffer of one - as we always send the end even if no one is listening
+		id:   MakeID(name),
+		name: name,
+		C:    make(chan *Params, 1), // a buffer of one - as we always send the end even if no one is listening
 	}
 	mn.SetWorkFlow(w)
 	w.registerNode(mn)
 	return mn
 }
 
+// make a loop node - an error is returned if the spec's While expression is not valid
+func (w *Workflow) MakeLoopNode(name string, spec LoopSpec) (*LoopNode, error) {
+	ln := &LoopNode{
+		id:   MakeID(name),
+		name: name,
+		spec: spec,
+		C:    make(chan *Params, 1), // a buffer of one - as we always send the end even if no one is listening
+	}
+
+	if spec.While != "" {
+		cond, err := ParseCondition(spec.While)
+		if err != nil {
+			return nil, err
+		}
+		ln.while = cond
+	}
+
+	ln.SetWorkFlow(w)
+	w.registerNode(ln)
+	return ln, nil
+}
+
+// make a for each node - the body is built once here so any errors in it are found before the flow runs
+func (w *Workflow) MakeForEachNode(name string, spec ForEachSpec) (*ForEachNode, error) {
+	if spec.Body == nil {
+		return nil, errors.New("for each node needs a body")
+	}
+
+	tmpl := MakeWorkflow()
+	tmpl.Name = w.Name
+	if err := spec.Body(tmpl); err != nil {
+		return nil, err
+	}
+
+	fe := &ForEachNode{
+		id:       MakeID(name),
+		name:     name,
+		spec:     spec,
+		template: tmpl,
+		streams:  map[string]*io.PipeWriter{},
+		C:        make(chan *Params, 1), // a buffer of one - as we always send the end even if no one is listening
+	}
+	fe.SetWorkFlow(w)
+	w.registerNode(fe)
+	return fe, nil
+}
+
+// make a node that runs the given launcher as a step in this flow
+func (w *Workflow) MakeSubFlowNode(name string, l *FlowLauncher, spec SubFlowSpec) (*SubFlowNode, error) {
+	if l == nil {
+		return nil, errors.New("sub flow node needs a launcher")
+	}
+
+	sn := &SubFlowNode{
+		id:       MakeID(name),
+		name:     name,
+		launcher: l,
+		spec:     spec,
+		C:        make(chan *Params, 1), // a buffer of one - as we always send the end even if no one is listening
+	}
+	sn.SetWorkFlow(w)
+	w.registerNode(sn)
+	return sn, nil
+}
+
+// make a node that waits for someone to approve it - through the Approvals registry
+func (w *Workflow) MakeGateNode(name string, spec GateSpec) *GateNode {
+	gn := &GateNode{
+		id:       MakeID(name),
+		name:     name,
+		spec:     spec,
+		registry: Approvals,
+		C:        make(chan *Params, 1), // a buffer of one - as we always send the end even if no one is listening
+	}
+	gn.SetWorkFlow(w)
+	w.registerNode(gn)
+	return gn
+}
+
 func (w *Workflow) MakeTriggerNode(name string, t Task) *TaskNode {
 	tn := w.MakeTaskNode(name, t)
 	tn.tType = "trigger"
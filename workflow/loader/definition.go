@@ -0,0 +1,117 @@
+package loader
+
+// the structures that a project definition file is decoded into - they can be written in json or yaml
+
+type ProjectDef struct {
+	Name     string     `json:"name" yaml:"name"`
+	Flows    []*FlowDef `json:"flows" yaml:"flows"`       // flows that can be launched
+	Triggers []*FlowDef `json:"triggers" yaml:"triggers"` // trigger flows that launch other flows
+	MaxRuns  int        `json:"max_runs" yaml:"max_runs"` // the most runs started from the queue at once across all flows
+}
+
+type FlowDef struct {
+	Name    string            `json:"name" yaml:"name"`
+	Order   int               `json:"order" yaml:"order"`
+	Threads int               `json:"threads" yaml:"threads"`
+	MaxRuns int               `json:"max_runs" yaml:"max_runs"` // the most runs of this flow started from the queue at once
+	Matrix  []*MatrixAxisDef  `json:"matrix" yaml:"matrix"`     // a thread for each combination of the values - instead of threads
+	Feed    *FeedDef          `json:"feed" yaml:"feed"`         // extra props for each thread from a file
+	Load    *LoadDef          `json:"load" yaml:"load"`         // how the threads start and how long they keep running
+	Envs    []string          `json:"envs" yaml:"envs"`         // only load this flow for these environments - empty means all
+	Initial string            `json:"initial" yaml:"initial"`   // name of a flow to run before this one
+	Trigger string            `json:"trigger" yaml:"trigger"`   // name of the trigger flow that launches this one
+	Props   map[string]string `json:"props" yaml:"props"`
+	Start   string            `json:"start" yaml:"start"`
+	End     string            `json:"end" yaml:"end"`
+	Nodes   []*NodeDef        `json:"nodes" yaml:"nodes"`
+}
+
+const (
+	KIND_TASK    = "task"
+	KIND_TRIGGER = "trigger"
+	KIND_MERGE   = "merge"
+	KIND_LOOP    = "loop"
+	KIND_FOREACH = "foreach"
+	KIND_SUBFLOW = "subflow"
+	KIND_GATE    = "gate"
+)
+
+type NodeDef struct {
+	Name     string            `json:"name" yaml:"name"`
+	Kind     string            `json:"kind" yaml:"kind"` // task (default), trigger, merge, loop, foreach, subflow or gate
+	Task     string            `json:"task" yaml:"task"` // the registered task type e.g. exec
+	Args     map[string]string `json:"args" yaml:"args"` // passed to the task maker
+	Timeout  string            `json:"timeout" yaml:"timeout"`
+	Retry    *RetryDef         `json:"retry" yaml:"retry"`
+	Triggers []string          `json:"triggers" yaml:"triggers"` // the nodes a merge node waits on
+	Join     *JoinDef          `json:"join" yaml:"join"`         // how a merge node waits - defaults to all
+	Loop     *LoopDef          `json:"loop" yaml:"loop"`         // when a loop node goes round again - the body is its next with status loop
+	ForEach  *ForEachDef       `json:"foreach" yaml:"foreach"`   // the items and body of a foreach node
+	SubFlow  *SubFlowDef       `json:"subflow" yaml:"subflow"`   // the flow a subflow node runs
+	Message  string            `json:"message" yaml:"message"`   // what a gate node asks to be approved - its timeout rejects it
+	Next     []*EdgeDef        `json:"next" yaml:"next"`
+}
+
+type EdgeDef struct {
+	Status string `json:"status" yaml:"status"` // e.g. success, fail, timeout - defaults to success
+	If     string `json:"if" yaml:"if"`         // only follow the edge if this expression is true - e.g. ExitStatus == 2
+	To     string `json:"to" yaml:"to"`
+}
+
+type RetryDef struct {
+	MaxAttempts int      `json:"max_attempts" yaml:"max_attempts"`
+	Backoff     string   `json:"backoff" yaml:"backoff"` // fixed (default) or exponential
+	Delay       string   `json:"delay" yaml:"delay"`
+	MaxDelay    string   `json:"max_delay" yaml:"max_delay"`
+	RetryOn     []string `json:"retry_on" yaml:"retry_on"`
+	ExitCodes   []int    `json:"exit_codes" yaml:"exit_codes"`
+}
+
+type MatrixAxisDef struct {
+	Name   string   `json:"name" yaml:"name"` // the prop set to each value
+	Values []string `json:"values" yaml:"values"`
+}
+
+type FeedDef struct {
+	File  string `json:"file" yaml:"file"`   // a .csv with a header line or a .jsonl file - a row for each thread
+	Order string `json:"order" yaml:"order"` // sequential (default), round-robin or random
+}
+
+type LoadDef struct {
+	Ramp        string  `json:"ramp" yaml:"ramp"` // linear, step or rate - empty starts all the threads at once
+	RampUp      string  `json:"ramp_up" yaml:"ramp_up"`
+	Steps       int     `json:"steps" yaml:"steps"`
+	StepEvery   string  `json:"step_every" yaml:"step_every"`
+	Rate        float64 `json:"rate" yaml:"rate"`         // flow runs started a second
+	Duration    string  `json:"duration" yaml:"duration"` // keep the threads running for this long
+	SampleEvery string  `json:"sample_every" yaml:"sample_every"`
+}
+
+type JoinDef struct {
+	Mode           string `json:"mode" yaml:"mode"` // all (default), any or n
+	N              int    `json:"n" yaml:"n"`
+	RequireSuccess bool   `json:"require_success" yaml:"require_success"`
+}
+
+type LoopDef struct {
+	While        string `json:"while" yaml:"while"`
+	Count        int    `json:"count" yaml:"count"`
+	UntilSuccess bool   `json:"until_success" yaml:"until_success"`
+	Max          int    `json:"max" yaml:"max"`
+}
+
+type ForEachDef struct {
+	Items     string     `json:"items" yaml:"items"` // the prop with the list e.g. <task>.files from an ls task
+	Separator string     `json:"separator" yaml:"separator"`
+	Parallel  int        `json:"parallel" yaml:"parallel"`
+	Start     string     `json:"start" yaml:"start"` // the body run for each item - its nodes are separate from the flows
+	End       string     `json:"end" yaml:"end"`
+	Nodes     []*NodeDef `json:"nodes" yaml:"nodes"`
+}
+
+type SubFlowDef struct {
+	Flow      string            `json:"flow" yaml:"flow"` // the name of another flow in the project
+	In        map[string]string `json:"in" yaml:"in"`     // props for the flow - values can use ${...}
+	Out       map[string]string `json:"out" yaml:"out"`   // outputs of the node from the flows <task>.<key> outputs - empty means all
+	StepDelay string            `json:"step_delay" yaml:"step_delay"`
+}
@@ -0,0 +1,667 @@
+package loader
+
+import (
+	"encoding/json"
+	"errors"
+	f "floe/workflow/flow"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// load a project definition from a .json, .yaml or .yml file and build the project from it
+// only flows that match env are included
+func LoadProject(path, env string) (*f.Project, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	def, err := ParseProject(body, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return BuildProject(def, env)
+}
+
+// decode a project definition - ext picks the format, .yaml and .yml are yaml anything else is json
+func ParseProject(body []byte, ext string) (*ProjectDef, error) {
+	def := &ProjectDef{}
+
+	var err error
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(body, def)
+	default:
+		err = json.Unmarshal(body, def)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// a launchable flow made from a definition
+type definedFlow struct {
+	f.BaseLaunchable
+	def       *FlowDef
+	trigger   bool
+	launchers map[string]*f.FlowLauncher // for sub flow nodes
+}
+
+func (d *definedFlow) GetProps() *f.Props {
+	p := d.DefaultProps()
+	if d.trigger {
+		(*p)[f.KEY_TIDY_DESK] = "keep" // dont trash the trigger state
+	}
+	for k, v := range d.def.Props {
+		(*p)[k] = v
+	}
+	return p
+}
+
+// the definition has already been built once without error so this should not fail - if it does the
+// flow fails validation with the error so the run is refused
+func (d *definedFlow) FlowFunc(threadId int) *f.Workflow {
+	w, err := buildWorkflow(d.def, d.launchers)
+	if err != nil {
+		glog.Error("could not build flow ", d.Name(), " ", err)
+		return f.MakeBrokenWorkflow(err)
+	}
+	return w
+}
+
+// make the project with a launcher for each flow and trigger definition
+func BuildProject(def *ProjectDef, env string) (*f.Project, error) {
+	p := f.MakeProject(def.Name)
+	if def.MaxRuns < 0 {
+		return nil, errors.New("max_runs can not be negative")
+	}
+	p.MaxRuns = def.MaxRuns
+
+	b := &projectBuilder{
+		def:       def,
+		env:       env,
+		project:   p,
+		launchers: map[string]*f.FlowLauncher{},
+		building:  map[string]bool{},
+		flows:     map[string]*FlowDef{},
+		triggers:  map[string]*FlowDef{},
+		usedTrigs: map[string]bool{},
+	}
+
+	for _, fd := range def.Flows {
+		if _, ok := b.flows[f.MakeID(fd.Name)]; ok {
+			return nil, errors.New("duplicate flow: " + fd.Name)
+		}
+		b.flows[f.MakeID(fd.Name)] = fd
+	}
+
+	for _, td := range def.Triggers {
+		if _, ok := b.triggers[f.MakeID(td.Name)]; ok {
+			return nil, errors.New("duplicate trigger: " + td.Name)
+		}
+		b.triggers[f.MakeID(td.Name)] = td
+	}
+
+	for _, fd := range def.Flows {
+		if !fd.forEnv(env) {
+			continue
+		}
+		if _, err := b.flow(fd.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	// triggers that dont launch a flow still get run
+	for _, td := range def.Triggers {
+		if b.usedTrigs[f.MakeID(td.Name)] || !td.forEnv(env) {
+			continue
+		}
+		tl, err := b.trigger(td.Name)
+		if err != nil {
+			return nil, err
+		}
+		p.AddTriggerFlow(tl)
+	}
+
+	return p, nil
+}
+
+type projectBuilder struct {
+	def       *ProjectDef
+	env       string
+	project   *f.Project
+	launchers map[string]*f.FlowLauncher
+	building  map[string]bool // to spot flows that are each others initial flows
+	flows     map[string]*FlowDef
+	triggers  map[string]*FlowDef
+	usedTrigs map[string]bool
+}
+
+// get or make the launcher for the named flow and any flows it depends on
+func (b *projectBuilder) flow(name string) (*f.FlowLauncher, error) {
+	id := f.MakeID(name)
+	if l, ok := b.launchers[id]; ok {
+		return l, nil
+	}
+
+	fd, ok := b.flows[id]
+	if !ok {
+		return nil, errors.New("flow not defined: " + name)
+	}
+
+	if b.building[id] {
+		return nil, errors.New("flow depends on itself as an initial or sub flow: " + name)
+	}
+	b.building[id] = true
+
+	// any flows run by sub flow nodes need launchers first
+	for _, sub := range subFlows(fd.Nodes) {
+		if _, err := b.flow(sub); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := buildWorkflow(fd, b.launchers); err != nil {
+		return nil, fmt.Errorf("flow %s: %v", name, err)
+	}
+
+	var initial, trigger *f.FlowLauncher
+	var err error
+	if fd.Initial != "" {
+		initial, err = b.flow(fd.Initial)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if fd.Trigger != "" {
+		trigger, err = b.trigger(fd.Trigger)
+		if err != nil {
+			return nil, err
+		}
+		b.usedTrigs[f.MakeID(fd.Trigger)] = true
+	}
+
+	threads := fd.Threads
+	if threads < 1 {
+		threads = 1
+	}
+
+	df := &definedFlow{def: fd, launchers: b.launchers}
+	df.Init(fd.Name)
+
+	l := f.MakeFlowLauncher(df, threads, initial, trigger)
+	if fd.MaxRuns < 0 {
+		return nil, fmt.Errorf("flow %s: max_runs can not be negative", name)
+	}
+	l.MaxRuns = fd.MaxRuns
+	if len(fd.Matrix) > 0 {
+		if fd.Threads > 1 {
+			return nil, fmt.Errorf("flow %s: threads can not be set with a matrix", name)
+		}
+		m := f.Matrix{}
+		for _, ad := range fd.Matrix {
+			m = append(m, f.MatrixAxis{Name: ad.Name, Values: ad.Values})
+		}
+		if err := l.SetMatrix(m); err != nil {
+			return nil, fmt.Errorf("flow %s: %v", name, err)
+		}
+	}
+	if fd.Feed != nil {
+		ff, err := f.NewFileFeeder(fd.Feed.File, fd.Feed.Order)
+		if err != nil {
+			return nil, fmt.Errorf("flow %s: %v", name, err)
+		}
+		l.SetFeeder(ff)
+	}
+
+	if fd.Load != nil {
+		lp, err := fd.Load.profile()
+		if err == nil {
+			err = l.SetLoad(*lp)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("flow %s: %v", name, err)
+		}
+	}
+
+	if fd.Order != 0 {
+		b.project.AddOrderedFlow(l, fd.Order)
+	} else {
+		b.project.AddFlow(l)
+	}
+
+	b.launchers[id] = l
+	return l, nil
+}
+
+// get or make the launcher for the named trigger flow
+func (b *projectBuilder) trigger(name string) (*f.FlowLauncher, error) {
+	id := f.MakeID(name)
+	if l, ok := b.launchers[id]; ok {
+		return l, nil
+	}
+
+	td, ok := b.triggers[id]
+	if !ok {
+		return nil, errors.New("trigger not defined: " + name)
+	}
+
+	if _, err := buildWorkflow(td, nil); err != nil {
+		return nil, fmt.Errorf("trigger %s: %v", name, err)
+	}
+
+	df := &definedFlow{def: td, trigger: true}
+	df.Init(td.Name)
+
+	l := b.project.MakeTriggerLauncher(td.Name, df.FlowFunc)
+	l.Props = df.GetProps()
+
+	b.launchers[id] = l
+	return l, nil
+}
+
+func (fd *FlowDef) forEnv(env string) bool {
+	if len(fd.Envs) == 0 {
+		return true
+	}
+	for _, e := range fd.Envs {
+		if e == env {
+			return true
+		}
+	}
+	return false
+}
+
+// build a fresh workflow from the definition - called once per thread
+func buildWorkflow(fd *FlowDef, launchers map[string]*f.FlowLauncher) (*f.Workflow, error) {
+	w := f.MakeWorkflow()
+	w.Name = fd.Name
+	if err := buildNodes(w, fd.Start, fd.End, fd.Nodes, launchers); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// add the nodes to the workflow and wire them up - used for flows and the bodies of for each nodes
+func buildNodes(w *f.Workflow, start, endName string, nodeDefs []*NodeDef, launchers map[string]*f.FlowLauncher) error {
+	nodes := map[string]f.TriggeredTaskNode{}
+	taskNodes := map[string]*f.TaskNode{}
+	mergeNodes := map[string]*f.MergeNode{}
+	loopNodes := map[string]*f.LoopNode{}
+	forEachNodes := map[string]*f.ForEachNode{}
+	subFlowNodes := map[string]*f.SubFlowNode{}
+	gateNodes := map[string]*f.GateNode{}
+
+	// make all the nodes first so edges can point forwards
+	for _, nd := range nodeDefs {
+		if nd.Name == "" {
+			return errors.New("node with no name")
+		}
+		if _, ok := nodes[nd.Name]; ok {
+			return errors.New("duplicate node: " + nd.Name)
+		}
+
+		timeout, err := optDuration(nd.Timeout)
+		if err != nil {
+			return fmt.Errorf("node %s timeout: %v", nd.Name, err)
+		}
+
+		switch nd.Kind {
+		case KIND_MERGE:
+			mn := w.MakeMergeNode(nd.Name)
+			mn.SetTimeout(timeout)
+			if nd.Join != nil {
+				j, err := nd.Join.join()
+				if err != nil {
+					return fmt.Errorf("node %s join: %v", nd.Name, err)
+				}
+				mn.SetJoin(j)
+			}
+			nodes[nd.Name] = mn
+			mergeNodes[nd.Name] = mn
+
+		case KIND_LOOP:
+			if nd.Loop == nil {
+				return fmt.Errorf("loop node %s has no loop definition", nd.Name)
+			}
+			ln, err := w.MakeLoopNode(nd.Name, f.LoopSpec{
+				While:        nd.Loop.While,
+				Count:        nd.Loop.Count,
+				UntilSuccess: nd.Loop.UntilSuccess,
+				Max:          nd.Loop.Max,
+			})
+			if err != nil {
+				return fmt.Errorf("node %s loop: %v", nd.Name, err)
+			}
+			nodes[nd.Name] = ln
+			loopNodes[nd.Name] = ln
+
+		case KIND_FOREACH:
+			fe := nd.ForEach
+			if fe == nil {
+				return fmt.Errorf("for each node %s has no for each definition", nd.Name)
+			}
+			fen, err := w.MakeForEachNode(nd.Name, f.ForEachSpec{
+				Items:     fe.Items,
+				Separator: fe.Separator,
+				Parallel:  fe.Parallel,
+				Body: func(bw *f.Workflow) error {
+					return buildNodes(bw, fe.Start, fe.End, fe.Nodes, launchers)
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("node %s body: %v", nd.Name, err)
+			}
+			nodes[nd.Name] = fen
+			forEachNodes[nd.Name] = fen
+
+		case KIND_SUBFLOW:
+			sd := nd.SubFlow
+			if sd == nil || sd.Flow == "" {
+				return fmt.Errorf("sub flow node %s has no flow", nd.Name)
+			}
+			l, ok := launchers[f.MakeID(sd.Flow)]
+			if !ok {
+				return fmt.Errorf("sub flow node %s runs unknown flow: %s", nd.Name, sd.Flow)
+			}
+			delay, err := optDuration(sd.StepDelay)
+			if err != nil {
+				return fmt.Errorf("node %s step delay: %v", nd.Name, err)
+			}
+			sn, err := w.MakeSubFlowNode(nd.Name, l, f.SubFlowSpec{
+				In:        sd.In,
+				Out:       sd.Out,
+				StepDelay: delay,
+			})
+			if err != nil {
+				return fmt.Errorf("node %s: %v", nd.Name, err)
+			}
+			nodes[nd.Name] = sn
+			subFlowNodes[nd.Name] = sn
+
+		case KIND_GATE:
+			gn := w.MakeGateNode(nd.Name, f.GateSpec{
+				Message: nd.Message,
+				Timeout: timeout,
+			})
+			nodes[nd.Name] = gn
+			gateNodes[nd.Name] = gn
+
+		case "", KIND_TASK, KIND_TRIGGER:
+			mk, ok := taskMakers[nd.Task]
+			if !ok {
+				return fmt.Errorf("node %s has unknown task type: %q", nd.Name, nd.Task)
+			}
+			t, err := mk(Args(nd.Args))
+			if err != nil {
+				return fmt.Errorf("node %s: %v", nd.Name, err)
+			}
+
+			var tn *f.TaskNode
+			if nd.Kind == KIND_TRIGGER {
+				tn = w.MakeTriggerNode(nd.Name, t)
+			} else {
+				tn = w.MakeTaskNode(nd.Name, t)
+			}
+			tn.SetTimeout(timeout)
+
+			if nd.Retry != nil {
+				rp, err := nd.Retry.policy()
+				if err != nil {
+					return fmt.Errorf("node %s retry: %v", nd.Name, err)
+				}
+				tn.SetRetry(rp)
+			}
+
+			nodes[nd.Name] = tn
+			taskNodes[nd.Name] = tn
+
+		default:
+			return fmt.Errorf("node %s has unknown kind: %q", nd.Name, nd.Kind)
+		}
+	}
+
+	// now wire them together
+	for _, nd := range nodeDefs {
+		if mn, ok := mergeNodes[nd.Name]; ok {
+			for _, tName := range nd.Triggers {
+				t, ok := nodes[tName]
+				if !ok {
+					return fmt.Errorf("merge %s has unknown trigger: %s", nd.Name, tName)
+				}
+				if err := mn.AddTrigger(t); err != nil {
+					return err
+				}
+			}
+		} else if len(nd.Triggers) > 0 {
+			return fmt.Errorf("only merge nodes have triggers: %s", nd.Name)
+		}
+
+		for _, ed := range nd.Next {
+			to, ok := nodes[ed.To]
+			if !ok {
+				return fmt.Errorf("node %s has next to unknown node: %s", nd.Name, ed.To)
+			}
+
+			if mn, ok := mergeNodes[nd.Name]; ok {
+				if ed.If != "" {
+					return fmt.Errorf("merge %s can not have conditional next nodes", nd.Name)
+				}
+				// a merge with no status always fires its next node
+				if ed.Status == "" {
+					tn, ok := taskNodes[ed.To]
+					if !ok {
+						return fmt.Errorf("merge %s next must be a task node: %s", nd.Name, ed.To)
+					}
+					mn.SetNext(tn)
+					continue
+				}
+				status, err := parseStatus(ed.Status)
+				if err != nil {
+					return fmt.Errorf("node %s: %v", nd.Name, err)
+				}
+				if err := mn.AddNext(status, to); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// loops, for each, sub flow and gate nodes only route on their status - success or fail for a gate
+			var sn interface {
+				AddNext(int, f.TriggeredTaskNode) error
+			}
+			if ln, ok := loopNodes[nd.Name]; ok {
+				sn = ln
+			} else if fen, ok := forEachNodes[nd.Name]; ok {
+				sn = fen
+			} else if sfn, ok := subFlowNodes[nd.Name]; ok {
+				sn = sfn
+			} else if gn, ok := gateNodes[nd.Name]; ok {
+				sn = gn
+			}
+			if sn != nil {
+				if ed.If != "" {
+					return fmt.Errorf("node %s can not have conditional next nodes", nd.Name)
+				}
+				status, err := parseStatus(ed.Status)
+				if err != nil {
+					return fmt.Errorf("node %s: %v", nd.Name, err)
+				}
+				if err := sn.AddNext(status, to); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// a condition on its own is followed whatever the status
+			if ed.If != "" {
+				expr := ed.If
+				if ed.Status != "" {
+					status, err := parseStatus(ed.Status)
+					if err != nil {
+						return fmt.Errorf("node %s: %v", nd.Name, err)
+					}
+					expr = fmt.Sprintf("Status == %d && (%s)", status, ed.If)
+				}
+				if err := taskNodes[nd.Name].AddNextIf(expr, to); err != nil {
+					return fmt.Errorf("node %s: %v", nd.Name, err)
+				}
+				continue
+			}
+
+			status, err := parseStatus(ed.Status)
+			if err != nil {
+				return fmt.Errorf("node %s: %v", nd.Name, err)
+			}
+			if err := taskNodes[nd.Name].AddNext(status, to); err != nil {
+				return err
+			}
+		}
+	}
+
+	if start != "" {
+		tn, ok := taskNodes[start]
+		if !ok {
+			return errors.New("start must be a task node: " + start)
+		}
+		w.SetStart(tn)
+	}
+
+	if endName == "" {
+		return errors.New("no end node")
+	}
+	end, ok := nodes[endName]
+	if !ok {
+		return errors.New("unknown end node: " + endName)
+	}
+	w.SetEnd(end)
+
+	return nil
+}
+
+// the names of the flows run by sub flow nodes - including inside for each bodies
+func subFlows(nds []*NodeDef) []string {
+	names := []string{}
+	for _, nd := range nds {
+		if nd.SubFlow != nil && nd.SubFlow.Flow != "" {
+			names = append(names, nd.SubFlow.Flow)
+		}
+		if nd.ForEach != nil {
+			names = append(names, subFlows(nd.ForEach.Nodes)...)
+		}
+	}
+	return names
+}
+
+func (jd *JoinDef) join() (f.Join, error) {
+	j := f.Join{
+		N:              jd.N,
+		RequireSuccess: jd.RequireSuccess,
+	}
+
+	switch jd.Mode {
+	case "", "all":
+		j.Mode = f.JOIN_ALL
+	case "any":
+		j.Mode = f.JOIN_ANY
+	case "n":
+		j.Mode = f.JOIN_N
+		if jd.N < 1 {
+			return j, errors.New("n must be at least 1")
+		}
+	default:
+		return j, errors.New("unknown mode: " + jd.Mode)
+	}
+
+	return j, nil
+}
+
+func (ld *LoadDef) profile() (*f.LoadProfile, error) {
+	lp := &f.LoadProfile{
+		Ramp:  ld.Ramp,
+		Steps: ld.Steps,
+		Rate:  ld.Rate,
+	}
+
+	var err error
+	if lp.RampUp, err = optDuration(ld.RampUp); err != nil {
+		return nil, err
+	}
+	if lp.StepEvery, err = optDuration(ld.StepEvery); err != nil {
+		return nil, err
+	}
+	if lp.Duration, err = optDuration(ld.Duration); err != nil {
+		return nil, err
+	}
+	if lp.SampleEvery, err = optDuration(ld.SampleEvery); err != nil {
+		return nil, err
+	}
+	return lp, nil
+}
+
+func (rd *RetryDef) policy() (*f.RetryPolicy, error) {
+	rp := &f.RetryPolicy{
+		MaxAttempts:    rd.MaxAttempts,
+		RetryExitCodes: rd.ExitCodes,
+	}
+
+	switch rd.Backoff {
+	case "", "fixed":
+		rp.Backoff = f.BACKOFF_FIXED
+	case "exponential":
+		rp.Backoff = f.BACKOFF_EXPONENTIAL
+	default:
+		return nil, errors.New("unknown backoff: " + rd.Backoff)
+	}
+
+	var err error
+	if rp.Delay, err = optDuration(rd.Delay); err != nil {
+		return nil, err
+	}
+	if rp.MaxDelay, err = optDuration(rd.MaxDelay); err != nil {
+		return nil, err
+	}
+
+	for _, s := range rd.RetryOn {
+		status, err := parseStatus(s)
+		if err != nil {
+			return nil, err
+		}
+		rp.RetryOn = append(rp.RetryOn, status)
+	}
+
+	return rp, nil
+}
+
+// a status by name or number - empty is success
+func parseStatus(s string) (int, error) {
+	if s == "" {
+		return f.SUCCESS, nil
+	}
+	if st, ok := f.StatusValues[strings.ToLower(s)]; ok {
+		return st, nil
+	}
+	st, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("unknown status: " + s)
+	}
+	return st, nil
+}
+
+func optDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
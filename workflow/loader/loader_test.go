@@ -0,0 +1,161 @@
+package loader
+
+import (
+	f "floe/workflow/flow"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProject = `{
+	"name": "test project",
+	"max_runs": 3,
+	"triggers": [{
+		"name": "on push",
+		"end": "push",
+		"nodes": [
+			{"name": "push", "kind": "trigger", "task": "delay-trigger", "args": {"delay": "1s"}}
+		]
+	}],
+	"flows": [{
+		"name": "build",
+		"matrix": [{"name": "go", "values": ["1.9", "1.10"]}, {"name": "os", "values": ["linux", "darwin"]}],
+		"end": "compile",
+		"start": "compile",
+		"nodes": [
+			{"name": "compile", "task": "exec", "args": {"cmd": "make"}}
+		]
+	}, {
+		"name": "deploy",
+		"threads": 2,
+		"max_runs": 1,
+		"load": {"ramp": "step", "steps": 2, "step_every": "5s", "duration": "1m"},
+		"initial": "build",
+		"trigger": "on push",
+		"props": {"target": "staging"},
+		"start": "checkout",
+		"end": "done",
+		"nodes": [
+			{"name": "checkout", "task": "exec", "args": {"cmd": "git", "args": "pull"}, "timeout": "30s",
+				"retry": {"max_attempts": 3, "backoff": "exponential", "delay": "1s"},
+				"next": [{"to": "lint"}, {"to": "test"}, {"status": "timeout", "to": "done"}]},
+			{"name": "lint", "task": "exec", "args": {"cmd": "golint"}},
+			{"name": "test", "task": "exec", "args": {"cmd": "go", "args": "test"}},
+			{"name": "done", "kind": "merge", "triggers": ["lint", "test"]}
+		]
+	}]
+}`
+
+func Test_BuildProject(t *testing.T) {
+	def, err := ParseProject([]byte(testProject), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := BuildProject(def, "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.FlowLaunchers) != 3 {
+		t.Error("expected build, deploy and the trigger launchers got", len(p.FlowLaunchers))
+	}
+
+	if len(p.Triggers) != 1 {
+		t.Error("expected the trigger flow to be registered", len(p.Triggers))
+	}
+
+	b := p.FlowLaunchers["build"]
+	if b.Threads != 4 || len(b.Cells()) != 4 || b.Cells()[1].Label != "go=1.9,os=darwin" {
+		t.Error("matrix not expanded", b.Threads, b.Cells())
+	}
+
+	l, ok := p.FlowLaunchers["deploy"]
+	if !ok {
+		t.Fatal("missing deploy launcher")
+	}
+
+	if l.Threads != 2 {
+		t.Error("wrong threads", l.Threads)
+	}
+
+	if p.MaxRuns != 3 || l.MaxRuns != 1 || b.MaxRuns != 0 {
+		t.Error("run limits not set", p.MaxRuns, l.MaxRuns, b.MaxRuns)
+	}
+
+	if lp := l.Load(); lp == nil || lp.Ramp != f.RAMP_STEP || lp.StepEvery != 5*time.Second || lp.Duration != time.Minute {
+		t.Error("load profile not set", lp)
+	}
+
+	if (*l.Props)["target"] != "staging" {
+		t.Error("props not set")
+	}
+
+	w := l.MakeFlow(0)
+	if w.Start == nil || w.Start.Id() != "checkout" {
+		t.Error("bad start node")
+	}
+
+	if w.End == nil || w.End.Type() != "merge" {
+		t.Error("bad end node")
+	}
+
+	if w.Start.Timeout() == 0 || w.Start.Retry() == nil || w.Start.Retry().Backoff != f.BACKOFF_EXPONENTIAL {
+		t.Error("timeout or retry not set")
+	}
+
+	if len(w.Start.Next[f.SUCCESS]) != 2 || len(w.Start.Next[f.TIMEOUT]) != 1 {
+		t.Error("bad edges", w.Start.Next)
+	}
+}
+
+func Test_BuildProjectErrors(t *testing.T) {
+	bad := []string{
+		`{"flows": [{"name": "a", "end": "x", "nodes": [{"name": "x", "task": "nope"}]}]}`,
+		`{"flows": [{"name": "a", "end": "y", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"flows": [{"name": "a", "end": "x", "nodes": [{"name": "x", "task": "exec", "next": [{"to": "z"}]}]}]}`,
+		`{"flows": [{"name": "a", "end": "x", "nodes": [{"name": "x", "task": "exec", "next": [{"if": "a ==", "to": "x"}]}]}]}`,
+		`{"flows": [{"name": "a", "end": "x", "nodes": [{"name": "x", "kind": "loop", "loop": {"while": "("}}]}]}`,
+		`{"flows": [{"name": "a", "end": "x", "nodes": [{"name": "x", "kind": "foreach", "foreach": {"items": "i", "start": "y", "end": "y", "nodes": [{"name": "y", "task": "nope"}]}}]}]}`,
+		`{"flows": [{"name": "a", "end": "x", "nodes": [{"name": "x", "kind": "subflow", "subflow": {"flow": "nope"}}]}]}`,
+		`{"flows": [{"name": "a", "end": "x", "nodes": [{"name": "x", "kind": "subflow", "subflow": {"flow": "a"}}]}]}`,
+		`{"flows": [{"name": "a", "initial": "a", "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"flows": [{"name": "a", "threads": 2, "matrix": [{"name": "os", "values": ["linux"]}], "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"flows": [{"name": "a", "matrix": [{"name": "os"}], "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"flows": [{"name": "a", "feed": {"file": "users.txt"}, "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"flows": [{"name": "a", "load": {"ramp": "linear"}, "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"flows": [{"name": "a", "load": {"duration": "forever"}, "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"flows": [{"name": "a", "feed": {"file": "users.csv", "order": "shuffle"}, "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"max_runs": -1, "flows": [{"name": "a", "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+		`{"flows": [{"name": "a", "max_runs": -1, "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`,
+	}
+
+	for i, b := range bad {
+		def, err := ParseProject([]byte(b), ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := BuildProject(def, ""); err == nil {
+			t.Error("expected an error for definition", i)
+		}
+	}
+}
+
+func Test_BrokenFlowRefused(t *testing.T) {
+	def, err := ParseProject([]byte(`{"flows": [{"name": "a", "start": "x", "end": "x", "nodes": [{"name": "x", "task": "exec"}]}]}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := BuildProject(def, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the definition goes bad after the project was built
+	def.Flows[0].Nodes[0].Task = "nope"
+
+	issues := p.FlowLaunchers["a"].Validate()
+	if !issues.HasErrors() || !strings.Contains(issues.Error(), "could not be made") {
+		t.Error("a flow that could not be made should not be runnable", issues)
+	}
+}
@@ -0,0 +1,73 @@
+package loader
+
+import (
+	"errors"
+	"floe/tasks"
+	triggers "floe/triggers"
+	f "floe/workflow/flow"
+	"strconv"
+	"time"
+)
+
+// the arguments given to a task in the definition
+type Args map[string]string
+
+// makes a task from its arguments
+type TaskMaker func(args Args) (f.Task, error)
+
+var taskMakers = map[string]TaskMaker{
+	"exec": func(a Args) (f.Task, error) {
+		return tasks.MakeExecTask(a["cmd"], a["args"], a["path"]), nil
+	},
+	"ssh-exec": func(a Args) (f.Task, error) {
+		if a["node"] == "" {
+			return nil, errors.New("ssh-exec needs a node")
+		}
+		return tasks.MakeSSHExecTask(a["node"], a["remote-path"], a["cmd"], a["path"]), nil
+	},
+	"ls": func(a Args) (f.Task, error) {
+		return tasks.MakeLsTask(a["path"]), nil
+	},
+	"delay": func(a Args) (f.Task, error) {
+		d, err := a.duration("delay")
+		if err != nil {
+			return nil, err
+		}
+		return tasks.MakeDelayTask(d), nil
+	},
+	"delay-trigger": func(a Args) (f.Task, error) {
+		d, err := a.duration("delay")
+		if err != nil {
+			return nil, err
+		}
+		return triggers.MakeDelayTrigger(d), nil
+	},
+	"git-push": func(a Args) (f.Task, error) {
+		if a["repo"] == "" {
+			return nil, errors.New("git-push needs a repo")
+		}
+		// the trigger takes its interval in seconds
+		interval := 10
+		if a["interval"] != "" {
+			i, err := strconv.Atoi(a["interval"])
+			if err != nil {
+				return nil, errors.New("git-push interval must be a number of seconds")
+			}
+			interval = i
+		}
+		return triggers.MakeGitPushTrigger(a["repo"], a["branch"], time.Duration(interval)), nil
+	},
+}
+
+// RegisterTask makes a task type available to definition files - use this to add custom tasks
+func RegisterTask(taskType string, mk TaskMaker) {
+	taskMakers[taskType] = mk
+}
+
+func (a Args) duration(key string) (time.Duration, error) {
+	d, err := time.ParseDuration(a[key])
+	if err != nil {
+		return 0, errors.New("bad " + key + " duration: " + err.Error())
+	}
+	return d, nil
+}